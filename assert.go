@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// result is what a query returned, read from the output of a client
+type result struct {
+	columns []string
+	rows    [][]string
+}
+
+var (
+	rowcountAssertRegex = regexp.MustCompile(`^(?i:rowcount)\s*(=|==|!=|<>|>=|<=|>|<)\s*(\d+)$`)
+	columnAssertRegex   = regexp.MustCompile(`^(?i:col)\((\w+)\)\s+(.+)$`)
+	inAssertRegex       = regexp.MustCompile(`^(?i:(not\s+)?in)\s*\((.*)\)$`)
+	nullAssertRegex     = regexp.MustCompile(`^(?i:is\s+(not\s+)?null)$`)
+	compareAssertRegex  = regexp.MustCompile(`^(=|==|!=|<>|>=|<=|>|<)\s*(.+)$`)
+)
+
+// nullOutput is how a client prints NULL when it isn't an empty value. the
+// mysql client prints the word in batch mode, sqlite and psql print nothing.
+var nullOutput = map[string]string{"mysql": "NULL"}
+
+// parseResult reads tab separated client output with a header line. clients
+// print nothing at all for an empty result.
+func parseResult(output string) result {
+	var r result
+	for _, line := range strings.Split(strings.TrimRight(output, "\n"), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if r.columns == nil {
+			if line != "" {
+				r.columns = strings.Split(line, "\t")
+			}
+			continue
+		}
+		r.rows = append(r.rows, strings.Split(line, "\t"))
+	}
+	return r
+}
+
+// checkAssert checks an @assert expression against a result and explains
+// what's wrong when it doesn't hold. the dialect says how NULL looks in the
+// result.
+func checkAssert(expr string, r result, dialect string) error {
+	expr = strings.TrimSpace(expr)
+
+	if m := rowcountAssertRegex.FindStringSubmatch(expr); m != nil {
+		expected, _ := strconv.Atoi(m[2])
+		if !compare(strconv.Itoa(len(r.rows)), m[1], strconv.Itoa(expected)) {
+			return fmt.Errorf("expected rowcount %s %d, got %d", m[1], expected, len(r.rows))
+		}
+		return nil
+	}
+
+	m := columnAssertRegex.FindStringSubmatch(expr)
+	if m == nil {
+		return fmt.Errorf("can't understand assertion %q", expr)
+	}
+	column, check := m[1], strings.TrimSpace(m[2])
+	index := -1
+	for i, c := range r.columns {
+		if c == column {
+			index = i
+		}
+	}
+	if index == -1 && len(r.rows) > 0 {
+		return fmt.Errorf("the result has no column '%s'", column)
+	}
+
+	// every row has to pass
+	var holds func(value string) bool
+	switch {
+	case inAssertRegex.MatchString(check):
+		in := inAssertRegex.FindStringSubmatch(check)
+		allowed := make(map[string]bool)
+		for _, v := range splitValues(in[2]) {
+			allowed[v] = true
+		}
+		negate := in[1] != ""
+		holds = func(value string) bool { return allowed[value] != negate }
+	case nullAssertRegex.MatchString(check):
+		negate := nullAssertRegex.FindStringSubmatch(check)[1] != ""
+		holds = func(value string) bool { return (value == "" || value == nullOutput[dialect]) != negate }
+	case compareAssertRegex.MatchString(check):
+		cmp := compareAssertRegex.FindStringSubmatch(check)
+		expected := unquote(strings.TrimSpace(cmp[2]))
+		holds = func(value string) bool { return compare(value, cmp[1], expected) }
+	default:
+		return fmt.Errorf("can't understand assertion %q", expr)
+	}
+
+	for i, row := range r.rows {
+		value := ""
+		if index < len(row) {
+			value = row[index]
+		}
+		if !holds(value) {
+			return fmt.Errorf("%s: row %d has %s = '%s'", expr, i+1, column, value)
+		}
+	}
+	return nil
+}
+
+// splitValues splits a list of sql values like 'a', 'b, c', 3
+func splitValues(list string) []string {
+	var values []string
+	var current strings.Builder
+	quoted := false
+	for i := 0; i < len(list); i++ {
+		c := list[i]
+		switch {
+		case c == '\'' && quoted && i+1 < len(list) && list[i+1] == '\'':
+			current.WriteByte(c)
+			i++
+		case c == '\'':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			values = append(values, strings.TrimSpace(current.String()))
+			current.Reset()
+		case c == ' ' && !quoted:
+		default:
+			current.WriteByte(c)
+		}
+	}
+	return append(values, strings.TrimSpace(current.String()))
+}
+
+// unquote removes the quotes around an sql string
+func unquote(value string) string {
+	if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+		return strings.ReplaceAll(value[1:len(value)-1], "''", "'")
+	}
+	return value
+}
+
+// compare compares numbers as numbers and anything else as strings
+func compare(a, op, b string) bool {
+	var c int
+	x, errA := strconv.ParseFloat(a, 64)
+	y, errB := strconv.ParseFloat(b, 64)
+	switch {
+	case errA == nil && errB == nil && x < y:
+		c = -1
+	case errA == nil && errB == nil && x > y:
+		c = 1
+	case errA == nil && errB == nil:
+		c = 0
+	default:
+		c = strings.Compare(a, b)
+	}
+
+	switch op {
+	case "=", "==":
+		return c == 0
+	case "!=", "<>":
+		return c != 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	case "<":
+		return c < 0
+	default:
+		return c <= 0
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseResult(t *testing.T) {
+	r := parseResult("id\tstatus\n1\tactive\n2\t\n")
+	expected := result{columns: []string{"id", "status"}, rows: [][]string{{"1", "active"}, {"2", ""}}}
+	if !reflect.DeepEqual(r, expected) {
+		t.Errorf("expected %v, got %v", expected, r)
+	}
+
+	if r := parseResult(""); r.columns != nil || len(r.rows) != 0 {
+		t.Errorf("expected an empty result, got %v", r)
+	}
+}
+
+func TestCheckAssert(t *testing.T) {
+	r := parseResult("id\tstatus\ttotal\n1\tcompleted\t29.99\n2\tpending\t450\n3\tit's done\t\n")
+
+	testCases := []struct {
+		expr  string
+		holds bool
+	}{
+		{"rowcount > 0", true},
+		{"rowcount = 3", true},
+		{"ROWCOUNT <= 2", false},
+		{"rowcount != 3", false},
+		{"col(status) in ('completed', 'pending', 'it''s done')", true},
+		{"col(status) in ('completed','pending')", false},
+		{"col(status) not in ('cancelled')", true},
+		{"col(id) in (1, 2, 3)", true},
+		{"col(id) > 0", true},
+		{"col(id) < 3", false},
+		{"col(total) is not null", false},
+		{"col(status) is not null", true},
+		{"col(status) != 'cancelled'", true},
+		{"col(nosuch) = 1", false},
+		{"col(status) like 'x'", false},
+		{"nonsense", false},
+	}
+
+	for _, tc := range testCases {
+		if err := checkAssert(tc.expr, r, "sqlite"); (err == nil) != tc.holds {
+			t.Errorf("checkAssert(%q): expected it to hold %v, got %v", tc.expr, tc.holds, err)
+		}
+	}
+
+	// column checks hold for empty results
+	if err := checkAssert("col(status) = 'active'", result{}, "sqlite"); err != nil {
+		t.Errorf("expected no error for an empty result, got %v", err)
+	}
+	if err := checkAssert("rowcount = 0", result{}, "sqlite"); err != nil {
+		t.Errorf("expected no error for an empty result, got %v", err)
+	}
+}
+
+func TestCheckAssertMySQLNull(t *testing.T) {
+	// the mysql client prints NULL where sqlite prints nothing
+	r := parseResult("id\tdeleted_at\n1\tNULL\n2\tNULL\n")
+
+	if err := checkAssert("col(deleted_at) is null", r, "mysql"); err != nil {
+		t.Errorf("expected NULL to be null on mysql, got %v", err)
+	}
+	if err := checkAssert("col(deleted_at) is not null", r, "mysql"); err == nil {
+		t.Error("expected NULL not to pass is not null on mysql, got no error")
+	}
+	if err := checkAssert("col(id) is not null", r, "mysql"); err != nil {
+		t.Errorf("expected ids to be set, got %v", err)
+	}
+}
+
+func TestSplitValues(t *testing.T) {
+	values := splitValues("'a', 'b, c', 3, 'it''s'")
+	expected := []string{"a", "b, c", "3", "it's"}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("expected %v, got %v", expected, values)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/kalli/sqlyac/queryfile"
+)
+
+// captureFormat is sent before a query with captures so the client prints
+// its result as tab separated columns with a header, which we can read back.
+// the mysql client already does that when its input isn't a terminal.
+var captureFormat = map[string]string{
+	"sqlite":   ".headers on\n.mode tabs",
+	"postgres": "\\set QUIET on\n\\pset format unaligned\n\\pset fieldsep '\\t'\n\\pset footer off",
+}
+
+var numberRegex = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+
+// hasCaptures tells whether any of the queries captures variables
+func hasCaptures(queries []queryfile.Query) bool {
+	for _, q := range queries {
+		if len(q.Captures) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCaptures makes sure captured variables can be filled in before the
+// queries that use them run
+func checkCaptures(queries []queryfile.Query, opts runOptions) error {
+	for _, q := range queries {
+		if len(q.Captures) == 0 {
+			continue
+		}
+		switch {
+		case opts.conn == nil:
+			return fmt.Errorf("%s captures variables, that needs a connection (--conn)", q.Name)
+		case opts.explain || opts.tx:
+			return fmt.Errorf("%s captures variables, that doesn't work with --explain or --tx", q.Name)
+		}
+	}
+	return nil
+}
+
+// captureVariables reads the values of the query's captures from the output
+// of the client and stores them in variables as literals of the dialect
+func captureVariables(q queryfile.Query, output, dialect string, variables map[string]string) error {
+	for _, c := range q.Captures {
+		value, ok := firstRowValue(output, c.Column)
+		if !ok {
+			return fmt.Errorf("can't capture %s, the result has no column '%s' or no rows", c.Variable, c.Column)
+		}
+		variables[c.Variable] = sqlLiteral(value, dialect)
+		fmt.Fprintf(os.Stderr, "captured %s = %s\n", c.Variable, variables[c.Variable])
+	}
+	return nil
+}
+
+// firstRowValue finds the first header with the column in the output of a
+// client and returns the column's value in the row after it. it reads tab
+// separated output and tables with | between columns.
+func firstRowValue(output, column string) (string, bool) {
+	var lines []string
+	for _, line := range strings.Split(output, "\n") {
+		// skip the borders of tables
+		if strings.Trim(line, "+-| \r") != "" {
+			lines = append(lines, strings.TrimRight(line, "\r"))
+		}
+	}
+
+	for i, line := range lines[:max(len(lines)-1, 0)] {
+		for j, name := range splitRow(line) {
+			if name != column {
+				continue
+			}
+			row := splitRow(lines[i+1])
+			if j < len(row) {
+				return row[j], true
+			}
+		}
+	}
+	return "", false
+}
+
+// splitRow splits a line of client output into its columns
+func splitRow(line string) []string {
+	if strings.Contains(line, "\t") {
+		return strings.Split(line, "\t")
+	}
+	if !strings.Contains(line, "|") {
+		return []string{strings.TrimSpace(line)}
+	}
+	line = strings.TrimSpace(line)
+	line = strings.TrimSuffix(strings.TrimPrefix(line, "|"), "|")
+	var fields []string
+	for _, field := range strings.Split(line, "|") {
+		fields = append(fields, strings.TrimSpace(field))
+	}
+	return fields
+}
+
+// sqlLiteral turns a captured value into something that can go in sql,
+// numbers are used as they are, empty values are NULL and anything else is
+// a quoted string. mysql reads backslashes in strings as escapes so they're
+// doubled there.
+func sqlLiteral(value, dialect string) string {
+	if value == "" || value == "NULL" {
+		return "NULL"
+	}
+	if numberRegex.MatchString(value) {
+		return value
+	}
+	if dialect == "mysql" {
+		value = strings.ReplaceAll(value, `\`, `\\`)
+	}
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/kalli/sqlyac/queryfile"
+)
+
+func TestFirstRowValue(t *testing.T) {
+	testCases := []struct {
+		output   string
+		column   string
+		expected string
+		found    bool
+		desc     string
+	}{
+		{"id\tusername\n2\tbob\n3\tcarol\n", "username", "bob", true, "tab separated"},
+		{"id\n7\n", "id", "7", true, "a single column"},
+		{"+----+----------+\n| id | username |\n+----+----------+\n|  2 | bob      |\n+----+----------+\n", "username", "bob", true, "mysql table"},
+		{" id | username \n----+----------\n  2 | bob\n(1 row)\n", "id", "2", true, "psql table"},
+		{"changes\n1\nid\temail\n4\t\n", "email", "", true, "a later result"},
+		{"id\tusername\n", "id", "", false, "no rows"},
+		{"id\tusername\n2\tbob\n", "email", "", false, "no such column"},
+	}
+
+	for _, tc := range testCases {
+		value, found := firstRowValue(tc.output, tc.column)
+		if value != tc.expected || found != tc.found {
+			t.Errorf("%s: expected %q %v, got %q %v", tc.desc, tc.expected, tc.found, value, found)
+		}
+	}
+}
+
+func TestSQLLiteral(t *testing.T) {
+	testCases := map[string]string{
+		"42":      "42",
+		"-1.5":    "-1.5",
+		"bob":     "'bob'",
+		"o'brien": "'o''brien'",
+		"":        "NULL",
+		"NULL":    "NULL",
+		"1e5":     "'1e5'",
+	}
+	for value, expected := range testCases {
+		if got := sqlLiteral(value, "sqlite"); got != expected {
+			t.Errorf("sqlLiteral(%q) = %s, expected %s", value, got, expected)
+		}
+	}
+
+	// a backslash can't end the string or escape a quote on mysql
+	mysqlCases := map[string]string{
+		`C:\`:       `'C:\\'`,
+		`\' OR 1=1`: `'\\'' OR 1=1'`,
+	}
+	for value, expected := range mysqlCases {
+		if got := sqlLiteral(value, "mysql"); got != expected {
+			t.Errorf("sqlLiteral(%q, mysql) = %s, expected %s", value, got, expected)
+		}
+	}
+	if got := sqlLiteral(`C:\`, "postgres"); got != `'C:\'` {
+		t.Errorf("expected backslashes to stay as they are on postgres, got %s", got)
+	}
+}
+
+func TestCheckCaptures(t *testing.T) {
+	queries := []queryfile.Query{{Name: "FindUser", Captures: []queryfile.Capture{{Variable: "user_id", Column: "id"}}}}
+	conn := &Connection{Command: []string{"sqlite3"}}
+
+	if err := checkCaptures(queries, runOptions{conn: conn}); err != nil {
+		t.Errorf("expected captures to work with a connection, got %v", err)
+	}
+	if err := checkCaptures(queries, runOptions{}); err == nil {
+		t.Error("expected an error without a connection, got none")
+	}
+	if err := checkCaptures(queries, runOptions{conn: conn, tx: true}); err == nil {
+		t.Error("expected an error with --tx, got none")
+	}
+}
+
+func TestRunQueriesCapturesVariables(t *testing.T) {
+	conn, _ := sqliteConnection(t)
+	opts := runOptions{conn: &conn, dialect: "sqlite"}
+
+	queries := []queryfile.Query{
+		{Name: "FindUser", SQL: "SELECT id AS user_id, 'u' || id AS name FROM users WHERE active = 0;",
+			Captures: []queryfile.Capture{{Variable: "user_id", Column: "user_id"}, {Variable: "name", Column: "name"}}},
+		{Name: "Show", SQL: "SELECT @name, id FROM users WHERE id = @user_id;"},
+	}
+	variables := map[string]string{"other": "1"}
+
+	var out bytes.Buffer
+	if _, err := runQueries(queries, variables, &Config{}, opts, &out); err != nil {
+		t.Fatalf("runQueries failed: %v", err)
+	}
+	expected := "user_id\tname\n3\tu3\nu3|3\n"
+	if out.String() != expected {
+		t.Errorf("expected:\n%q\ngot:\n%q", expected, out.String())
+	}
+	// captures only last for the run
+	if len(variables) != 1 {
+		t.Errorf("expected the variables to be left alone, got %v", variables)
+	}
+}
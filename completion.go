@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// flags offered by completion, keep these in sync with main and the subcommands
+var (
+	mainFlags     = []string{"--file", "--name", "--var", "--confirm", "--explain", "--analyze", "--dry-run", "--tx", "--dialect", "--conn", "--all", "--tag", "--no-deps", "--last", "--yes-i-mean-prod"}
+	listFlags     = []string{"--tag", "--grep", "--kind", "--json"}
+	convertFlags  = []string{"--from", "--to"}
+	genFlags      = []string{"--package", "--placeholder"}
+	testFlags     = []string{"--tag", "--var", "--conn", "--format", "--allow-writes", "--yes-i-mean-prod"}
+	snapshotFlags = []string{"--tag", "--var", "--conn", "--verify", "--allow-writes", "--yes-i-mean-prod"}
+	historyFlags  = []string{"--query", "--file", "--conn", "--user", "--since", "--limit", "--json", "--rerun", "--var"}
+	valueFlags    = map[string]bool{
+		"--file": true, "--name": true, "--var": true, "--dialect": true, "--conn": true,
+		"--tag": true, "--grep": true, "--kind": true,
+		"--from": true, "--to": true,
+		"--package": true, "--placeholder": true, "--format": true,
+		"--query": true, "--user": true, "--since": true, "--limit": true, "--rerun": true,
+	}
+	subcommands = []string{"list", "convert", "gen", "test", "snapshot", "history", "completion"}
+	shells      = []string{"bash", "zsh", "fish"}
+)
+
+const bashCompletion = `# bash completion for sqlyac, load it with
+#   source <(sqlyac completion bash)
+_sqlyac() {
+    local IFS=$'\n'
+    COMPREPLY=($(sqlyac __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+    # keep typing after a directory or a variable name
+    if [[ ${#COMPREPLY[@]} -eq 1 && ( ${COMPREPLY[0]} == */ || ${COMPREPLY[0]} == *= ) ]]; then
+        compopt -o nospace
+    fi
+}
+complete -F _sqlyac sqlyac
+`
+
+const zshCompletion = `#compdef sqlyac
+# zsh completion for sqlyac, load it with
+#   source <(sqlyac completion zsh)
+_sqlyac() {
+    local candidate
+    for candidate in "${(@f)$(sqlyac __complete "${(@)words[2,CURRENT]}" 2>/dev/null)}"; do
+        [[ -z $candidate ]] && continue
+        # keep typing after a directory or a variable name
+        if [[ $candidate == */ || $candidate == *= ]]; then
+            compadd -S '' -- "$candidate"
+        else
+            compadd -- "$candidate"
+        fi
+    done
+}
+compdef _sqlyac sqlyac
+`
+
+const fishCompletion = `# fish completion for sqlyac, load it with
+#   sqlyac completion fish | source
+function __sqlyac_complete
+    set -l tokens (commandline -opc) (commandline -ct)
+    sqlyac __complete $tokens[2..-1] 2>/dev/null
+end
+complete -c sqlyac -f -a '(__sqlyac_complete)'
+`
+
+func runCompletion(args []string) {
+	scripts := map[string]string{
+		"bash": bashCompletion,
+		"zsh":  zshCompletion,
+		"fish": fishCompletion,
+	}
+	if len(args) != 1 || scripts[args[0]] == "" {
+		fmt.Fprintf(os.Stderr, "usage: sqlyac completion bash|zsh|fish\n")
+		os.Exit(1)
+	}
+	fmt.Print(scripts[args[0]])
+}
+
+// runComplete is called by the completion scripts with the words typed so
+// far, the last one being the word under the cursor
+func runComplete(args []string) {
+	for _, candidate := range complete(args) {
+		fmt.Println(candidate)
+	}
+}
+
+// complete returns the candidates for the last word in args
+func complete(args []string) []string {
+	if len(args) == 0 {
+		args = []string{""}
+	}
+	current := args[len(args)-1]
+	previous := args[:len(args)-1]
+
+	// work out what has been typed so far
+	var subcommand, file, queryName string
+	var positional []string
+	for i := 0; i < len(previous); i++ {
+		word := previous[i]
+		if valueFlags[word] && i+1 < len(previous) {
+			switch word {
+			case "--file":
+				file = previous[i+1]
+			case "--name":
+				queryName = previous[i+1]
+			}
+			i++
+			continue
+		}
+		if strings.HasPrefix(word, "-") {
+			continue
+		}
+		if i == 0 && (word == "list" || word == "convert" || word == "gen" || word == "test" || word == "snapshot" || word == "history" || word == "completion") {
+			subcommand = word
+			continue
+		}
+		if i == 1 && subcommand == "gen" && word == "go" {
+			continue
+		}
+		positional = append(positional, word)
+	}
+	if file == "" && len(positional) > 0 {
+		file = positional[0]
+		positional = positional[1:]
+	}
+	if queryName == "" && len(positional) > 0 {
+		queryName = positional[0]
+	}
+
+	var candidates []string
+	last := ""
+	if len(previous) > 0 {
+		last = previous[len(previous)-1]
+	}
+
+	switch {
+	case subcommand == "completion":
+		candidates = shells
+	case subcommand == "gen" && len(previous) == 1:
+		candidates = []string{"go"}
+	case last == "--placeholder":
+		candidates = []string{"?", "$"}
+	case last == "--file":
+		candidates = completeFiles(current)
+	case last == "--name":
+		candidates = completeQueryNames(file)
+	case last == "--var":
+		candidates = completeVariables(file, queryName)
+	case last == "--kind":
+		candidates = []string{"read", "write", "ddl"}
+	case last == "--from" || last == "--to":
+		candidates = convertFormats
+	case last == "--format":
+		candidates = []string{"tap", "junit"}
+	case last == "--dialect":
+		candidates = dialects
+	case last == "--conn":
+		for name := range loadConfigOrDefaults().Connections {
+			candidates = append(candidates, name)
+		}
+		sort.Strings(candidates)
+	case valueFlags[last]:
+		// free form values like --tag and --grep
+	case strings.HasPrefix(current, "-"):
+		candidates = mainFlags
+		switch subcommand {
+		case "list":
+			candidates = listFlags
+		case "convert":
+			candidates = convertFlags
+		case "gen":
+			candidates = genFlags
+		case "test":
+			candidates = testFlags
+		case "snapshot":
+			candidates = snapshotFlags
+		case "history":
+			candidates = historyFlags
+		}
+	case subcommand == "history":
+		// the search term is free form
+	case file == "":
+		candidates = completeFiles(current)
+		if len(previous) == 0 {
+			candidates = append(append([]string{}, subcommands...), candidates...)
+		}
+	case (subcommand == "" || subcommand == "test" || subcommand == "snapshot") && file != "":
+		// several queries can be run in one go
+		candidates = completeQueryNames(file)
+	}
+
+	var matches []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, current) {
+			matches = append(matches, candidate)
+		}
+	}
+	return matches
+}
+
+// completeFiles returns the directories and query files that start with prefix
+func completeFiles(prefix string) []string {
+	dir, base := filepath.Split(prefix)
+	entries, err := os.ReadDir(filepath.Join(".", dir))
+	if err != nil {
+		return nil
+	}
+
+	extensions := loadConfigOrDefaults().Extensions
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, base) || (strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".")) {
+			continue
+		}
+		if entry.IsDir() {
+			files = append(files, dir+name+"/")
+		} else if checkExtension(dir+name, extensions) == nil {
+			files = append(files, dir+name)
+		}
+	}
+	return files
+}
+
+func completeQueryNames(file string) []string {
+	queries, _, err := parseSQLWithOptions(file, loadConfigOrDefaults().parseOptions())
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, q := range queries {
+		names = append(names, q.Name)
+	}
+	return names
+}
+
+// completeVariables returns `name=` for every variable set in the file plus
+// the parameters of the chosen query
+func completeVariables(file, queryName string) []string {
+	queries, variables, err := parseSQLWithOptions(file, loadConfigOrDefaults().parseOptions())
+	if err != nil {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	for name := range variables {
+		seen[name] = true
+	}
+	if queryName != "" {
+		if q, _, err := findQuery(queries, queryName); err == nil {
+			for _, p := range q.AllParams() {
+				seen[p.Name] = true
+			}
+		}
+	}
+
+	var names []string
+	for name := range seen {
+		names = append(names, name+"=")
+	}
+	sort.Strings(names)
+	return names
+}
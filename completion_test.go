@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestComplete(t *testing.T) {
+	testCases := []struct {
+		args     []string
+		expected []string
+		desc     string
+	}{
+		{[]string{"exam"}, []string{"example.sql"}, "sql files"},
+		{[]string{"li"}, []string{"list"}, "subcommands"},
+		{[]string{"example.sql", "GetL"}, []string{"GetLargeOrders"}, "query names"},
+		{[]string{"--file", "example.sql", "--name", "Count"}, []string{"CountOrdersByStatus"}, "query names via flags"},
+		{[]string{"example.sql", "QueryWithVariables", "--var", "u"}, []string{"user_id="}, "variable names"},
+		{[]string{"example.sql", "GetAllUsers", "--conf"}, []string{"--confirm"}, "flags"},
+		{[]string{"example.sql", "GetAllUsers", "--c"}, []string{"--confirm", "--conn"}, "flags with a shared prefix"},
+		{[]string{"list", "example.sql", "--j"}, []string{"--json"}, "list flags"},
+		{[]string{"list", "example.sql", "--kind", "w"}, []string{"write"}, "kinds"},
+		{[]string{"list", "example.sql", ""}, nil, "list takes no query name"},
+		{[]string{"completion", "z"}, []string{"zsh"}, "shells"},
+		{[]string{"test", "example.sql", "--format", ""}, []string{"tap", "junit"}, "test formats"},
+		{[]string{"test", "example.sql", "GetL"}, []string{"GetLargeOrders"}, "test query names"},
+		{[]string{"example.sql", "GetAllUsers", "--dialect", "p"}, []string{"postgres"}, "dialects"},
+		{[]string{"example.sql", "GetAllUsers", "GetL"}, []string{"GetLargeOrders"}, "more query names"},
+		{[]string{"example.sql", "--a"}, []string{"--analyze", "--all"}, "all"},
+		{[]string{"--la"}, []string{"--last"}, "last"},
+		{[]string{"test", "example.sql", "--y"}, []string{"--yes-i-mean-prod"}, "test on a protected connection"},
+		{[]string{"history", "--r"}, []string{"--rerun"}, "history flags"},
+		{[]string{"history", "exam"}, nil, "history searches rather than taking a file"},
+		{[]string{"history", "--file", "exam"}, []string{"example.sql"}, "history files"},
+	}
+
+	for _, tc := range testCases {
+		if got := complete(tc.args); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("complete(%q) = %q, expected %q (%s)", tc.args, got, tc.expected, tc.desc)
+		}
+	}
+}
+
+func TestCompleteVariablesIncludesQueryParams(t *testing.T) {
+	vars := completeVariables("example.sql", "QueryWithVariables")
+	expected := []string{"active=", "lim=", "status=", "user_id="}
+	if !reflect.DeepEqual(vars, expected) {
+		t.Errorf("expected %v, got %v", expected, vars)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// Connection is a database client sqlyac can run queries with. the sql is
+// written to the command's stdin, like when you pipe sqlyac into it.
+type Connection struct {
+	Dialect string   `json:"dialect"`
+	Command []string `json:"command"`
+	// Protected connections ask before every write and want their name
+	// typed instead of y
+	Protected bool `json:"protected"`
+	// Name is the key of the connection in the config
+	Name string `json:"-"`
+}
+
+// stopOnError makes a client exit at the first error instead of running the
+// rest of the sql, mysql does that by default when it isn't interactive
+var stopOnError = map[string]string{
+	"sqlite":   ".bail on",
+	"postgres": `\set ON_ERROR_STOP on`,
+}
+
+// connection returns the connection with the given name from the config
+func (c *Config) connection(name string) (Connection, error) {
+	conn, ok := c.Connections[name]
+	if !ok {
+		var names []string
+		for n := range c.Connections {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			return Connection{}, fmt.Errorf("unknown connection '%s', add it to connections in ~/.sqlyac/config.json", name)
+		}
+		return Connection{}, fmt.Errorf("unknown connection '%s', expected one of: %s", name, strings.Join(names, ", "))
+	}
+	if len(conn.Command) == 0 {
+		return Connection{}, fmt.Errorf("connection '%s' has no command", name)
+	}
+	conn.Name = name
+	return conn, nil
+}
+
+// checkProtected refuses a protected connection when there's nobody to
+// confirm with, unless --yes-i-mean-prod says that's fine
+func checkProtected(conn Connection, interactive, yesIMeanProd bool) error {
+	if !conn.Protected || interactive || yesIMeanProd {
+		return nil
+	}
+	return fmt.Errorf("connection '%s' is protected and can't ask for confirmation here, pass --yes-i-mean-prod to run anyway", conn.Name)
+}
+
+// runSQL runs sql with the connection's client, its output goes to out and
+// its errors straight to ours. the client stops at the first error.
+func runSQL(conn Connection, dialect, sql string, out io.Writer) error {
+	if prelude := stopOnError[dialect]; prelude != "" {
+		sql = prelude + "\n" + sql
+	}
+	cmd := exec.Command(conn.Command[0], conn.Command[1:]...)
+	cmd.Stdin = strings.NewReader(sql + "\n")
+	cmd.Stdout = out
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// session keeps a client running so sqlyac can look at the output of some
+// statements before deciding what to send next, like whether to commit.
+// after each batch of sql it has the client print a marker so it knows when
+// the client is done with it. the client stops at the first error, so a
+// batch that never gets to its marker failed.
+type session struct {
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	stdout   *bufio.Reader
+	out      io.Writer
+	dialect  string
+	batches  int
+	finished chan struct{}
+	exited   bool
+	waitErr  error
+}
+
+// startSession starts the connection's client, its output is copied to out
+func startSession(conn Connection, dialect string, out io.Writer) (*session, error) {
+	cmd := exec.Command(conn.Command[0], conn.Command[1:]...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	s := &session{
+		cmd:      cmd,
+		stdin:    stdin,
+		stdout:   bufio.NewReader(stdout),
+		out:      out,
+		dialect:  dialect,
+		finished: make(chan struct{}),
+	}
+	go func() {
+		defer close(s.finished)
+		io.Copy(os.Stderr, stderr)
+	}()
+
+	if prelude := stopOnError[dialect]; prelude != "" {
+		if _, err := io.WriteString(stdin, prelude+"\n"); err != nil {
+			s.wait()
+			return nil, fmt.Errorf("the client exited: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// exec sends sql to the client and copies the output until the client has
+// run all of it. it fails when the client stops at an error or exits.
+func (s *session) exec(sql string) error {
+	if s.exited {
+		return fmt.Errorf("the client has exited")
+	}
+	s.batches++
+	marker := fmt.Sprintf("__sqlyac_done_%d__", s.batches)
+	markerSQL := map[string]string{
+		"sqlite":   ".print " + marker,
+		"postgres": `\echo ` + marker,
+		"mysql":    "SELECT '" + marker + "' AS '';",
+	}[s.dialect]
+
+	if _, err := io.WriteString(s.stdin, sql+"\n"+markerSQL+"\n"); err != nil {
+		s.wait()
+		return fmt.Errorf("the client exited: %w", err)
+	}
+
+	// the mysql marker comes with an empty header, or a box in table mode,
+	// so hold on to lines like that until we know they're not the marker's
+	var held []string
+	for {
+		line, err := s.stdout.ReadString('\n')
+		if err != nil {
+			// the client already printed the error itself
+			if err := s.wait(); err != nil {
+				return fmt.Errorf("the client stopped at an error: %w", err)
+			}
+			return fmt.Errorf("the client exited before running everything")
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		if strings.Contains(line, marker) {
+			// the rest of a mysql box
+			for s.stdout.Buffered() > 0 {
+				next, err := s.stdout.Peek(1)
+				if err != nil || next[0] != '+' {
+					break
+				}
+				s.stdout.ReadString('\n')
+			}
+			return nil
+		}
+		if strings.Trim(line, "+-| ") == "" {
+			held = append(held, line)
+			continue
+		}
+		for _, h := range held {
+			fmt.Fprintln(s.out, h)
+		}
+		held = nil
+		fmt.Fprintln(s.out, line)
+	}
+}
+
+// wait closes the client's input and waits for it to exit
+func (s *session) wait() error {
+	if !s.exited {
+		s.exited = true
+		s.stdin.Close()
+		io.Copy(s.out, s.stdout)
+		<-s.finished
+		s.waitErr = s.cmd.Wait()
+	}
+	return s.waitErr
+}
+
+// close ends the session, anything not committed is rolled back by the
+// database when the client disconnects
+func (s *session) close() error {
+	return s.wait()
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/kalli/sqlyac/queryfile"
+)
+
+var convertFormats = []string{"sqlyac", "sqlc", "yesql", "hugsql"}
+
+func runConvert(args []string) {
+	var from, to string
+
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	fs.StringVar(&from, "from", "sqlyac", "format of the input file (sqlyac, sqlc, yesql or hugsql)")
+	fs.StringVar(&to, "to", "sqlyac", "format to convert to (sqlyac, sqlc, yesql or hugsql)")
+	positional := parseArgs(fs, args)
+
+	if len(positional) != 1 || !isConvertFormat(from) || !isConvertFormat(to) {
+		fmt.Fprintf(os.Stderr, "usage: sqlyac convert --from sqlyac|sqlc|yesql|hugsql --to sqlyac|sqlc|yesql|hugsql <filepath>\n")
+		os.Exit(1)
+	}
+
+	config := loadConfigOrDefaults()
+	opts := config.parseOptions()
+	if from != "sqlyac" {
+		// sqlc and hugsql files use the same `-- name:` style as yesql
+		opts.Syntax = "yesql"
+	}
+
+	queries, _, err := parseSQLWithOptions(positional[0], opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error parsing sql: %v\n", err)
+		os.Exit(1)
+	}
+
+	for i := range queries {
+		queries[i] = importQuery(queries[i], from)
+	}
+	if err := writeQueries(os.Stdout, queries, to); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing queries: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func isConvertFormat(format string) bool {
+	for _, f := range convertFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	sqlcArgRegex       = regexp.MustCompile(`^sqlc\.(?:n?arg|slice)\(\s*'?(\w+)'?\s*\)`)
+	atParamRegex       = regexp.MustCompile(`^@(\w+)`)
+	dollarParamRegex   = regexp.MustCompile(`^\$(\d+)`)
+	questionParamRegex = regexp.MustCompile(`^\?`)
+	// hugsql has type prefixes like :v:ids or :v*:ids, yesql just :name
+	colonParamRegex = regexp.MustCompile(`^:(?:[a-z]+\*?:)?([A-Za-z][\w-]*)`)
+)
+
+// importQuery rewrites the parameters of a query parsed from another format
+// into sqlyac @variables and declares them as params
+func importQuery(q queryfile.Query, from string) queryfile.Query {
+	var patterns []*regexp.Regexp
+	switch from {
+	case "sqlc":
+		patterns = []*regexp.Regexp{sqlcArgRegex, atParamRegex, dollarParamRegex, questionParamRegex}
+	case "yesql", "hugsql":
+		patterns = []*regexp.Regexp{colonParamRegex, questionParamRegex}
+	default:
+		return q
+	}
+
+	var names []string
+	positional := 0
+	sql := queryfile.ReplaceParams(q.SQL, patterns, func(matches []string) string {
+		var name string
+		switch {
+		case matches[0] == "?":
+			positional++
+			name = fmt.Sprintf("arg%d", positional)
+		case strings.HasPrefix(matches[0], "$"):
+			name = "arg" + matches[1]
+		default:
+			name = strings.ReplaceAll(matches[1], "-", "_")
+		}
+		names = append(names, name)
+		return "@" + name
+	})
+
+	q.SQL = sql
+	q.Statements = queryfile.SplitStatements(sql)
+	q.Name = camelCase(q.Name)
+	declared := make(map[string]bool)
+	for _, p := range q.Params {
+		declared[p.Name] = true
+	}
+	for _, name := range names {
+		if !declared[name] {
+			q.Params = append(q.Params, queryfile.Param{Name: name})
+			declared[name] = true
+		}
+	}
+	return q
+}
+
+// writeQueries writes queries as a query file in the given format
+func writeQueries(w io.Writer, queries []queryfile.Query, to string) error {
+	var b strings.Builder
+	for i, q := range queries {
+		sql := q.SQL
+		result := q.Result
+		if result == "" && to != "sqlyac" {
+			// the others want to know what to do with the results
+			result = "many"
+			if queryfile.Kind(sql) != queryfile.KindRead {
+				result = "exec"
+			}
+		}
+
+		switch to {
+		case "sqlyac":
+			b.WriteString("---\n")
+			fmt.Fprintf(&b, "-- @name %s\n", camelCase(q.Name))
+			if q.Description != "" {
+				fmt.Fprintf(&b, "-- @description %s\n", q.Description)
+			}
+			if len(q.Tags) > 0 {
+				fmt.Fprintf(&b, "-- @tags %s\n", strings.Join(q.Tags, ", "))
+			}
+			if result != "" {
+				fmt.Fprintf(&b, "-- @result %s\n", result)
+			}
+			for _, p := range q.Params {
+				fmt.Fprintf(&b, "-- @param %s\n", strings.TrimSpace(strings.Join([]string{p.Name, p.Type, p.Description}, " ")))
+			}
+		case "sqlc":
+			fmt.Fprintf(&b, "-- name: %s :%s\n", camelCase(q.Name), result)
+			if q.Description != "" {
+				fmt.Fprintf(&b, "-- %s\n", q.Description)
+			}
+			sql = exportParams(sql, func(name string) string { return "sqlc.arg(" + name + ")" })
+		case "yesql":
+			suffix := map[string]string{"exec": "!", "execrows": "!", "execresult": "!", "execlastid": "<!"}[result]
+			fmt.Fprintf(&b, "-- name: %s%s\n", kebabCase(q.Name), suffix)
+			if q.Description != "" {
+				fmt.Fprintf(&b, "-- %s\n", q.Description)
+			}
+			sql = exportParams(sql, func(name string) string { return ":" + name })
+		case "hugsql":
+			hints := map[string]string{
+				"one": ":? :1", "many": ":? :*", "exec": ":!", "execrows": ":! :n",
+				"execresult": ":! :raw", "execlastid": ":i!",
+			}[result]
+			if hints == "" {
+				hints = ":? :*"
+			}
+			if result == "one" && queryfile.Kind(sql) != queryfile.KindRead {
+				hints = ":<! :1"
+			}
+			fmt.Fprintf(&b, "-- :name %s %s\n", kebabCase(q.Name), hints)
+			if q.Description != "" {
+				fmt.Fprintf(&b, "-- :doc %s\n", q.Description)
+			}
+			sql = exportParams(sql, func(name string) string { return ":" + name })
+		default:
+			return fmt.Errorf("unknown format %q", to)
+		}
+
+		b.WriteString(sql + "\n")
+		if i < len(queries)-1 {
+			b.WriteString("\n")
+		}
+	}
+	if to == "sqlyac" && len(queries) > 0 {
+		b.WriteString("---\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// exportParams replaces sqlyac @variables with another format's parameters
+func exportParams(sql string, param func(name string) string) string {
+	return queryfile.ReplaceParams(sql, []*regexp.Regexp{atParamRegex}, func(matches []string) string {
+		return param(matches[1])
+	})
+}
+
+// camelCase turns get-user or get_user into GetUser, names that are already
+// camel case are left alone
+func camelCase(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if r == '-' || r == '_' || r == '!' || r == '<' {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// kebabCase turns GetUser or get_user into get-user
+func kebabCase(name string) string {
+	var b strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if r == '_' {
+			r = '-'
+		}
+		if unicode.IsUpper(r) && i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]) ||
+			(i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1]))) {
+			b.WriteRune('-')
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
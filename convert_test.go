@@ -0,0 +1,151 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kalli/sqlyac/queryfile"
+)
+
+func TestImportQuery(t *testing.T) {
+	testCases := []struct {
+		from     string
+		query    queryfile.Query
+		expected queryfile.Query
+	}{
+		{
+			"sqlc",
+			queryfile.Query{Name: "GetAuthor", SQL: "SELECT * FROM authors WHERE id = $1 AND name = sqlc.arg(name) AND bio = @bio AND x::int = 1 AND email = 'a@b.com'"},
+			queryfile.Query{Name: "GetAuthor", SQL: "SELECT * FROM authors WHERE id = @arg1 AND name = @name AND bio = @bio AND x::int = 1 AND email = 'a@b.com'",
+				Params: []queryfile.Param{{Name: "arg1"}, {Name: "name"}, {Name: "bio"}}},
+		},
+		{
+			"sqlc",
+			queryfile.Query{Name: "DeleteAuthor", SQL: "DELETE FROM authors WHERE id = ? OR parent_id = ?"},
+			queryfile.Query{Name: "DeleteAuthor", SQL: "DELETE FROM authors WHERE id = @arg1 OR parent_id = @arg2",
+				Params: []queryfile.Param{{Name: "arg1"}, {Name: "arg2"}}},
+		},
+		{
+			"hugsql",
+			queryfile.Query{Name: "get-author", SQL: "SELECT * FROM authors WHERE id IN (:v*:ids) AND name = :author-name -- not :this\nAND ts > now()::date"},
+			queryfile.Query{Name: "GetAuthor", SQL: "SELECT * FROM authors WHERE id IN (@ids) AND name = @author_name -- not :this\nAND ts > now()::date",
+				Params: []queryfile.Param{{Name: "ids"}, {Name: "author_name"}}},
+		},
+	}
+
+	for _, tc := range testCases {
+		got := importQuery(tc.query, tc.from)
+		tc.expected.Statements = queryfile.SplitStatements(tc.expected.SQL)
+		if !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("importQuery from %s:\nexpected %+v\ngot      %+v", tc.from, tc.expected, got)
+		}
+	}
+}
+
+func TestWriteQueries(t *testing.T) {
+	queries := []queryfile.Query{
+		{Name: "GetUser", SQL: "SELECT * FROM users WHERE id = @user_id;", Description: "one user", Result: "one",
+			Params: []queryfile.Param{{Name: "user_id", Type: "int"}}},
+		{Name: "DeleteUser", SQL: "DELETE FROM users WHERE id = @user_id;"},
+	}
+
+	expected := map[string]string{
+		"sqlyac": `---
+-- @name GetUser
+-- @description one user
+-- @result one
+-- @param user_id int
+SELECT * FROM users WHERE id = @user_id;
+
+---
+-- @name DeleteUser
+DELETE FROM users WHERE id = @user_id;
+---
+`,
+		"sqlc": `-- name: GetUser :one
+-- one user
+SELECT * FROM users WHERE id = sqlc.arg(user_id);
+
+-- name: DeleteUser :exec
+DELETE FROM users WHERE id = sqlc.arg(user_id);
+`,
+		"yesql": `-- name: get-user
+-- one user
+SELECT * FROM users WHERE id = :user_id;
+
+-- name: delete-user!
+DELETE FROM users WHERE id = :user_id;
+`,
+		"hugsql": `-- :name get-user :? :1
+-- :doc one user
+SELECT * FROM users WHERE id = :user_id;
+
+-- :name delete-user :!
+DELETE FROM users WHERE id = :user_id;
+`,
+	}
+
+	for format, want := range expected {
+		var out strings.Builder
+		if err := writeQueries(&out, queries, format); err != nil {
+			t.Fatalf("writeQueries(%s) failed: %v", format, err)
+		}
+		if out.String() != want {
+			t.Errorf("writeQueries(%s):\nexpected:\n%s\ngot:\n%s", format, want, out.String())
+		}
+	}
+}
+
+func TestConvertRoundTrip(t *testing.T) {
+	sqlc := `-- name: GetAuthor :one
+SELECT * FROM authors WHERE id = sqlc.arg(id);
+
+-- name: ListAuthors :many
+SELECT * FROM authors;
+`
+
+	// sqlc -> sqlyac
+	file, err := queryfile.Parse(strings.NewReader(sqlc), "authors.sql", queryfile.Options{Syntax: "yesql"})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	queries := file.Queries
+	for i := range queries {
+		queries[i] = importQuery(queries[i], "sqlc")
+	}
+	var sqlyac strings.Builder
+	if err := writeQueries(&sqlyac, queries, "sqlyac"); err != nil {
+		t.Fatalf("writeQueries failed: %v", err)
+	}
+
+	// and back again
+	file, err = queryfile.Parse(strings.NewReader(sqlyac.String()), "authors.sql", queryfile.Options{})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	var back strings.Builder
+	if err := writeQueries(&back, file.Queries, "sqlc"); err != nil {
+		t.Fatalf("writeQueries failed: %v", err)
+	}
+	if back.String() != sqlc {
+		t.Errorf("expected the round trip to give back:\n%s\ngot:\n%s", sqlc, back.String())
+	}
+}
+
+func TestNameCases(t *testing.T) {
+	for _, tc := range []struct{ name, camel, kebab string }{
+		{"get-user", "GetUser", "get-user"},
+		{"get_user_by_id", "GetUserById", "get-user-by-id"},
+		{"GetUser", "GetUser", "get-user"},
+		{"GetHTTPLogs", "GetHTTPLogs", "get-http-logs"},
+		{"save-person!", "SavePerson", "save-person!"},
+	} {
+		if got := camelCase(tc.name); got != tc.camel {
+			t.Errorf("camelCase(%q) = %q, expected %q", tc.name, got, tc.camel)
+		}
+		if got := kebabCase(tc.name); got != tc.kebab {
+			t.Errorf("kebabCase(%q) = %q, expected %q", tc.name, got, tc.kebab)
+		}
+	}
+}
@@ -1,5 +1,7 @@
 ---
 -- @name CreateUsersTable
+-- @description Create the users table
+-- @tags setup
 CREATE TABLE users (
     id INTEGER PRIMARY KEY,
     username VARCHAR(50) NOT NULL,
@@ -11,6 +13,9 @@ CREATE TABLE users (
 
 ---
 -- @name CreateOrdersTable
+-- @description Create the orders table
+-- @tags setup
+-- @depends CreateUsersTable
 CREATE TABLE orders (
     id INTEGER PRIMARY KEY,
     user_id INTEGER,
@@ -22,6 +27,9 @@ CREATE TABLE orders (
 
 ---
 -- @name InsertSampleUsers
+-- @description Add a handful of sample users
+-- @tags setup, fixtures
+-- @depends CreateUsersTable
 INSERT INTO users (username, email) VALUES 
     ('alice', 'alice@example.com'),
     ('bob', 'bob@example.com'),
@@ -30,6 +38,9 @@ INSERT INTO users (username, email) VALUES
 
 ---
 -- @name InsertSampleOrders
+-- @description Add sample orders for the sample users
+-- @tags setup, fixtures
+-- @depends CreateOrdersTable, InsertSampleUsers
 INSERT INTO orders (user_id, total_amount, status) VALUES 
     (1, 29.99, 'completed'),
     (1, 15.50, 'completed'),
@@ -51,6 +62,8 @@ ORDER BY username;
 
 ---
 -- @name GetLargeOrders
+-- @description Orders over 100, biggest first
+-- @tags reports
 SELECT o.id, u.username, o.total_amount, o.status
 FROM orders o
 JOIN users u ON o.user_id = u.id
@@ -59,6 +72,8 @@ ORDER BY o.total_amount DESC;
 
 ---
 -- @name GetUserOrderSummary
+-- @description Order count, total and average spend per user
+-- @tags reports
 SELECT 
     u.username,
     COUNT(o.id) as order_count,
@@ -91,11 +106,17 @@ ORDER BY count DESC;
 
 ---
 -- @name CleanupTestData
+-- @description Drop all the example tables
+-- @tags teardown
+-- @allow-unbounded
 DROP TABLE IF EXISTS orders;
 DROP TABLE IF EXISTS users;
 
 ---
 -- @name QueryWithVariables
+-- @description Orders for a single user by status
+-- @param user_id int the user to look up
+-- @param status text order status to filter on
 SELECT * 
 FROM orders o, users u
 WHERE u.id=@user_id 
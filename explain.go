@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/kalli/sqlyac/queryfile"
+)
+
+// dialects are the databases sqlyac knows how to explain and dry run for
+var dialects = []string{"mysql", "postgres", "sqlite"}
+
+var (
+	// comments in front of a statement, the splitter keeps them
+	leadingCommentsRegex = regexp.MustCompile(`^(?s)(\s*(--[^\n]*(\n|$)|/\*.*?\*/))*\s*`)
+	// statements that start or end a transaction of their own
+	transactionRegex = regexp.MustCompile(`(?is)^(BEGIN(\s+(TRANSACTION|WORK|DEFERRED|IMMEDIATE|EXCLUSIVE))?|START\s+TRANSACTION.*|COMMIT.*|ROLLBACK.*|END(\s+TRANSACTION)?|SAVEPOINT.*|RELEASE.*)$`)
+)
+
+func isDialect(dialect string) bool {
+	for _, d := range dialects {
+		if d == dialect {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveDialect picks the dialect from the flag, the config or the file
+// extension, in that order, falling back to mysql like the `SET @var` syntax
+func resolveDialect(flagDialect, configDialect, path string) (string, error) {
+	dialect := flagDialect
+	if dialect == "" {
+		dialect = configDialect
+	}
+	if dialect == "" {
+		dialect = "mysql"
+		if strings.HasSuffix(path, ".pgsql") || strings.HasSuffix(path, ".psql") {
+			dialect = "postgres"
+		}
+	}
+	if !isDialect(dialect) {
+		return "", fmt.Errorf("unknown dialect %q, expected %s", dialect, strings.Join(dialects, ", "))
+	}
+	return dialect, nil
+}
+
+// explainSQL puts the dialect's EXPLAIN in front of every statement. with
+// analyze the statements really run, so they're wrapped in a transaction that
+// is rolled back
+func explainSQL(statements []string, dialect string, analyze bool) (string, error) {
+	prefix := map[string]string{"mysql": "EXPLAIN", "postgres": "EXPLAIN", "sqlite": "EXPLAIN QUERY PLAN"}[dialect]
+	if analyze {
+		if dialect == "sqlite" {
+			return "", fmt.Errorf("sqlite has no EXPLAIN ANALYZE")
+		}
+		prefix = "EXPLAIN ANALYZE"
+	}
+
+	var lines []string
+	for _, statement := range statements {
+		if queryfile.Kind(statement) == queryfile.KindDDL {
+			return "", fmt.Errorf("can't explain schema changes: %s", statementSummary(statement))
+		}
+		if err := checkTransactionControl(statement); err != nil {
+			return "", err
+		}
+		lines = append(lines, prefix+" "+leadingCommentsRegex.ReplaceAllString(statement, "")+";")
+	}
+	if analyze {
+		return wrapInRollback(lines, dialect), nil
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// dryRunSQL runs the statements in a transaction that is always rolled back
+// and reports the rows affected by each write
+func dryRunSQL(statements []string, dialect string) (string, error) {
+	lines, err := txStatements(statements, dialect, true)
+	if err != nil {
+		return "", err
+	}
+	return wrapInRollback(lines, dialect), nil
+}
+
+// wrapInRollback wraps lines of sql in a transaction that is rolled back
+func wrapInRollback(lines []string, dialect string) string {
+	return beginStatement(dialect) + "\n" + strings.Join(lines, "\n") + "\nROLLBACK;"
+}
+
+// checkTransactionControl refuses statements that would end our transaction
+// early and let the rest of the query commit
+func checkTransactionControl(statement string) error {
+	if transactionRegex.MatchString(leadingCommentsRegex.ReplaceAllString(statement, "")) {
+		return fmt.Errorf("the query controls its own transaction, it can't be rolled back: %s", statementSummary(statement))
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveDialect(t *testing.T) {
+	testCases := []struct {
+		flag, config, path string
+		expected           string
+	}{
+		{"", "", "queries.sql", "mysql"},
+		{"", "", "queries.pgsql", "postgres"},
+		{"", "", "queries.psql", "postgres"},
+		{"", "sqlite", "queries.pgsql", "sqlite"},
+		{"postgres", "sqlite", "queries.sql", "postgres"},
+	}
+
+	for _, tc := range testCases {
+		dialect, err := resolveDialect(tc.flag, tc.config, tc.path)
+		if err != nil || dialect != tc.expected {
+			t.Errorf("resolveDialect(%q, %q, %q) = %q, %v, expected %q", tc.flag, tc.config, tc.path, dialect, err, tc.expected)
+		}
+	}
+
+	if _, err := resolveDialect("oracle", "", "queries.sql"); err == nil {
+		t.Error("expected an error for an unknown dialect, got none")
+	}
+}
+
+func TestExplainSQL(t *testing.T) {
+	statements := []string{"-- the active ones\nSELECT * FROM users WHERE active = 1", "SELECT 1"}
+
+	testCases := []struct {
+		dialect  string
+		analyze  bool
+		expected string
+	}{
+		{"mysql", false, "EXPLAIN SELECT * FROM users WHERE active = 1;\nEXPLAIN SELECT 1;"},
+		{"sqlite", false, "EXPLAIN QUERY PLAN SELECT * FROM users WHERE active = 1;\nEXPLAIN QUERY PLAN SELECT 1;"},
+		{"postgres", true, "BEGIN;\nEXPLAIN ANALYZE SELECT * FROM users WHERE active = 1;\nEXPLAIN ANALYZE SELECT 1;\nROLLBACK;"},
+		{"mysql", true, "START TRANSACTION;\nEXPLAIN ANALYZE SELECT * FROM users WHERE active = 1;\nEXPLAIN ANALYZE SELECT 1;\nROLLBACK;"},
+	}
+
+	for _, tc := range testCases {
+		sql, err := explainSQL(statements, tc.dialect, tc.analyze)
+		if err != nil {
+			t.Fatalf("explainSQL(%s, %v) failed: %v", tc.dialect, tc.analyze, err)
+		}
+		if sql != tc.expected {
+			t.Errorf("explainSQL(%s, %v):\nexpected:\n%s\ngot:\n%s", tc.dialect, tc.analyze, tc.expected, sql)
+		}
+	}
+
+	if _, err := explainSQL(statements, "sqlite", true); err == nil {
+		t.Error("expected an error for EXPLAIN ANALYZE on sqlite, got none")
+	}
+	if _, err := explainSQL([]string{"DROP TABLE users"}, "mysql", false); err == nil {
+		t.Error("expected an error explaining a schema change, got none")
+	}
+}
+
+func TestDryRunSQL(t *testing.T) {
+	statements := []string{"UPDATE users SET active = 0", "SELECT COUNT(*) FROM users"}
+
+	sql, err := dryRunSQL(statements, "sqlite")
+	if err != nil {
+		t.Fatalf("dryRunSQL failed: %v", err)
+	}
+	expected := "BEGIN;\nUPDATE users SET active = 0;\nSELECT changes() AS rows_affected;\nSELECT COUNT(*) FROM users;\nROLLBACK;"
+	if sql != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, sql)
+	}
+
+	// psql prints the rows affected itself
+	sql, err = dryRunSQL(statements, "postgres")
+	if err != nil {
+		t.Fatalf("dryRunSQL failed: %v", err)
+	}
+	if strings.Contains(sql, "rows_affected") || !strings.HasPrefix(sql, "BEGIN;") {
+		t.Errorf("unexpected postgres dry run:\n%s", sql)
+	}
+
+	if _, err := dryRunSQL([]string{"CREATE TABLE t (id INT)"}, "mysql"); err == nil {
+		t.Error("expected an error for a schema change on mysql, got none")
+	}
+	if _, err := dryRunSQL([]string{"CREATE TABLE t (id INT)"}, "postgres"); err != nil {
+		t.Errorf("postgres can roll back schema changes, got %v", err)
+	}
+	for _, statement := range []string{"COMMIT", "BEGIN", "-- done\nBEGIN TRANSACTION", "start transaction", "ROLLBACK TO SAVEPOINT a"} {
+		if _, err := dryRunSQL([]string{"UPDATE users SET active = 0", statement}, "sqlite"); err == nil {
+			t.Errorf("expected an error for %q, got none", statement)
+		}
+	}
+	// BEGIN ... END blocks are not transactions
+	if _, err := dryRunSQL([]string{"CREATE TRIGGER t AFTER INSERT ON users BEGIN SELECT 1; END"}, "sqlite"); err != nil {
+		t.Errorf("expected a trigger to be fine, got %v", err)
+	}
+}
+
+func TestDryRunRollsBack(t *testing.T) {
+	sqlite, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 not installed")
+	}
+	db := filepath.Join(t.TempDir(), "test.db")
+
+	run := func(sql string) string {
+		cmd := exec.Command(sqlite, db)
+		cmd.Stdin = strings.NewReader(sql)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("sqlite3 failed: %v\n%s", err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	run("CREATE TABLE users (id INTEGER, active INTEGER); INSERT INTO users VALUES (1, 1), (2, 1), (3, 0);")
+
+	sql, err := dryRunSQL([]string{"UPDATE users SET active = 0 WHERE active = 1"}, "sqlite")
+	if err != nil {
+		t.Fatalf("dryRunSQL failed: %v", err)
+	}
+	if out := run(sql); out != "2" {
+		t.Errorf("expected 2 rows affected, got %q", out)
+	}
+	if out := run("SELECT COUNT(*) FROM users WHERE active = 1;"); out != "2" {
+		t.Errorf("expected the update to be rolled back, got %q active users", out)
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/kalli/sqlyac/queryfile"
+)
+
+// goTypes maps @param types to go types, anything else becomes any
+var goTypes = map[string]string{
+	"int": "int64", "integer": "int64", "bigint": "int64", "smallint": "int64", "tinyint": "int64", "serial": "int64",
+	"text": "string", "varchar": "string", "char": "string", "string": "string", "uuid": "string",
+	"bool": "bool", "boolean": "bool",
+	"float": "float64", "double": "float64", "real": "float64", "decimal": "float64", "numeric": "float64",
+	"timestamp": "time.Time", "timestamptz": "time.Time", "datetime": "time.Time", "date": "time.Time", "time": "time.Time",
+	"bytes": "[]byte", "blob": "[]byte", "bytea": "[]byte", "json": "[]byte", "jsonb": "[]byte",
+}
+
+// initialisms are kept upper case in go names, like UserID
+var initialisms = map[string]bool{
+	"ID": true, "URL": true, "URI": true, "HTTP": true, "SQL": true, "JSON": true,
+	"UUID": true, "API": true, "IP": true, "HTML": true, "XML": true,
+}
+
+func runGen(args []string) {
+	if len(args) == 0 || args[0] != "go" {
+		fmt.Fprintf(os.Stderr, "usage: sqlyac gen go <filepath> [--package <name>] [--placeholder ?|$]\n")
+		os.Exit(1)
+	}
+
+	var pkg, placeholder string
+	fs := flag.NewFlagSet("gen", flag.ExitOnError)
+	fs.StringVar(&pkg, "package", "queries", "package name of the generated code")
+	fs.StringVar(&placeholder, "placeholder", "?", "placeholder style, ? for mysql and sqlite or $ for postgres")
+	positional := parseArgs(fs, args[1:])
+
+	if len(positional) != 1 || (placeholder != "?" && placeholder != "$") {
+		fmt.Fprintf(os.Stderr, "usage: sqlyac gen go <filepath> [--package <name>] [--placeholder ?|$]\n")
+		os.Exit(1)
+	}
+
+	queries, _, err := parseSQLWithOptions(positional[0], loadConfigOrDefaults().parseOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error parsing sql: %v\n", err)
+		os.Exit(1)
+	}
+
+	code, err := generateGo(queries, pkg, placeholder, filepath.Base(positional[0]))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error generating go: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Print(code)
+}
+
+// generateGo turns queries into go functions using database/sql. each query
+// gets a constant with its sql, a params struct when it has parameters and a
+// function that runs it
+func generateGo(queries []queryfile.Query, pkg, placeholder, source string) (string, error) {
+	var b strings.Builder
+	usesTime := false
+
+	// every generated name belongs to one query, get_user and GetUser would
+	// both become GetUser
+	owners := map[string]string{"DBTX": "the DBTX interface"}
+	claim := func(ident, owner string) error {
+		if other, ok := owners[ident]; ok {
+			return fmt.Errorf("%s and %s both generate %s, rename one of them", other, owner, ident)
+		}
+		owners[ident] = owner
+		return nil
+	}
+
+	for _, q := range queries {
+		name := goName(q.Name)
+		if err := claim(name, "query "+q.Name); err != nil {
+			return "", err
+		}
+		if err := claim(name+"SQL", "query "+q.Name); err != nil {
+			return "", err
+		}
+
+		result := q.Result
+		if result == "" {
+			result = "many"
+			if queryfile.Kind(q.SQL) != queryfile.KindRead {
+				result = "exec"
+			}
+		}
+
+		// database/sql runs one statement per call, so queries with more
+		// than one run them one at a time
+		statements := []string{q.SQL}
+		if len(q.Statements) > 1 {
+			if result == "one" || result == "many" {
+				return "", fmt.Errorf("query %s has %d statements but returns rows, split it into one query per statement", q.Name, len(q.Statements))
+			}
+			statements = q.Statements
+		}
+		var bound []string
+		var args [][]string
+		for _, statement := range statements {
+			sql, statementArgs := queryfile.Bind(statement, placeholder)
+			bound = append(bound, sql)
+			args = append(args, statementArgs)
+		}
+
+		// params keep the declared order, then the order they're used in
+		types := make(map[string]string)
+		for _, p := range q.Params {
+			types[p.Name] = p.Type
+		}
+		var fields []string
+		fieldParams := make(map[string]string)
+		for _, p := range q.AllParams() {
+			field := goName(p.Name)
+			if other, ok := fieldParams[field]; ok {
+				return "", fmt.Errorf("query %s: params %s and %s both become the field %s, rename one of them", q.Name, other, p.Name, field)
+			}
+			fieldParams[field] = p.Name
+			goType, ok := goTypes[strings.ToLower(types[p.Name])]
+			if !ok {
+				goType = "any"
+			}
+			usesTime = usesTime || goType == "time.Time"
+			fields = append(fields, fmt.Sprintf("\t%s %s\n", field, goType))
+		}
+
+		quote := func(sql string) string {
+			return "`" + strings.ReplaceAll(sql, "`", "` + \"`\" + `") + "`"
+		}
+		if len(bound) == 1 {
+			fmt.Fprintf(&b, "// %sSQL is the sql of the %s query\n", name, q.Name)
+			fmt.Fprintf(&b, "const %sSQL = %s\n\n", name, quote(bound[0]))
+		} else {
+			fmt.Fprintf(&b, "// %sSQL are the statements of the %s query\n", name, q.Name)
+			fmt.Fprintf(&b, "var %sSQL = []string{\n", name)
+			for _, sql := range bound {
+				fmt.Fprintf(&b, "\t%s,\n", quote(sql))
+			}
+			fmt.Fprintf(&b, "}\n\n")
+		}
+
+		params := ""
+		if len(fields) > 0 {
+			if err := claim(name+"Params", "query "+q.Name); err != nil {
+				return "", err
+			}
+			fmt.Fprintf(&b, "// %sParams are the parameters of %s\n", name, name)
+			fmt.Fprintf(&b, "type %sParams struct {\n%s}\n\n", name, strings.Join(fields, ""))
+			params = fmt.Sprintf(", arg %sParams", name)
+		}
+		// call is the arguments of ExecContext and friends for a statement
+		call := func(i int) string {
+			call := name + "SQL"
+			if len(bound) > 1 {
+				call += fmt.Sprintf("[%d]", i)
+			}
+			for _, arg := range args[i] {
+				call += ", arg." + goName(arg)
+			}
+			return call
+		}
+
+		if len(bound) > 1 {
+			fmt.Fprintf(&b, "// %s runs the %s query one statement at a time, pass a *sql.Tx to\n// run all of them or none\n", name, q.Name)
+		} else {
+			fmt.Fprintf(&b, "// %s runs the %s query\n", name, q.Name)
+		}
+		if q.Description != "" {
+			fmt.Fprintf(&b, "//\n// %s\n", q.Description)
+		}
+
+		switch result {
+		case "one":
+			fmt.Fprintf(&b, "func %s(ctx context.Context, db DBTX%s) *sql.Row {\n", name, params)
+			fmt.Fprintf(&b, "\treturn db.QueryRowContext(ctx, %s)\n}\n\n", call(0))
+		case "execrows":
+			fmt.Fprintf(&b, "func %s(ctx context.Context, db DBTX%s) (int64, error) {\n", name, params)
+			if len(bound) == 1 {
+				fmt.Fprintf(&b, "\tresult, err := db.ExecContext(ctx, %s)\n", call(0))
+				fmt.Fprintf(&b, "\tif err != nil {\n\t\treturn 0, err\n\t}\n")
+				fmt.Fprintf(&b, "\treturn result.RowsAffected()\n}\n\n")
+				break
+			}
+			// the rows affected by all the statements
+			fmt.Fprintf(&b, "\tvar total int64\n")
+			for i := range bound {
+				assign := "="
+				if i == 0 {
+					assign = ":="
+				}
+				fmt.Fprintf(&b, "\tresult, err %s db.ExecContext(ctx, %s)\n", assign, call(i))
+				fmt.Fprintf(&b, "\tif err != nil {\n\t\treturn 0, err\n\t}\n")
+				fmt.Fprintf(&b, "\trows, err %s result.RowsAffected()\n", assign)
+				fmt.Fprintf(&b, "\tif err != nil {\n\t\treturn 0, err\n\t}\n")
+				fmt.Fprintf(&b, "\ttotal += rows\n")
+			}
+			fmt.Fprintf(&b, "\treturn total, nil\n}\n\n")
+		case "execlastid":
+			fmt.Fprintf(&b, "func %s(ctx context.Context, db DBTX%s) (int64, error) {\n", name, params)
+			for i := 0; i < len(bound)-1; i++ {
+				fmt.Fprintf(&b, "\tif _, err := db.ExecContext(ctx, %s); err != nil {\n\t\treturn 0, err\n\t}\n", call(i))
+			}
+			fmt.Fprintf(&b, "\tresult, err := db.ExecContext(ctx, %s)\n", call(len(bound)-1))
+			fmt.Fprintf(&b, "\tif err != nil {\n\t\treturn 0, err\n\t}\n")
+			fmt.Fprintf(&b, "\treturn result.LastInsertId()\n}\n\n")
+		case "exec", "execresult":
+			fmt.Fprintf(&b, "func %s(ctx context.Context, db DBTX%s) (sql.Result, error) {\n", name, params)
+			for i := 0; i < len(bound)-1; i++ {
+				fmt.Fprintf(&b, "\tif _, err := db.ExecContext(ctx, %s); err != nil {\n\t\treturn nil, err\n\t}\n", call(i))
+			}
+			fmt.Fprintf(&b, "\treturn db.ExecContext(ctx, %s)\n}\n\n", call(len(bound)-1))
+		default:
+			fmt.Fprintf(&b, "func %s(ctx context.Context, db DBTX%s) (*sql.Rows, error) {\n", name, params)
+			fmt.Fprintf(&b, "\treturn db.QueryContext(ctx, %s)\n}\n\n", call(0))
+		}
+	}
+
+	imports := "\t\"context\"\n\t\"database/sql\"\n"
+	if usesTime {
+		imports += "\t\"time\"\n"
+	}
+	header := fmt.Sprintf(`// Code generated by sqlyac from %s. DO NOT EDIT.
+
+package %s
+
+import (
+%s)
+
+// DBTX is what the generated functions run queries on, *sql.DB, *sql.Tx and
+// *sql.Conn all satisfy it
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+`, source, pkg, imports)
+
+	code, err := format.Source([]byte(header + b.String()))
+	if err != nil {
+		return "", err
+	}
+	return string(code), nil
+}
+
+// goName turns a query or variable name into an exported go name, so
+// user_id becomes UserID and get-user becomes GetUser
+func goName(name string) string {
+	var b strings.Builder
+	for _, word := range strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if upper := strings.ToUpper(word); initialisms[upper] {
+			b.WriteString(upper)
+			continue
+		}
+		runes := []rune(word)
+		b.WriteString(strings.ToUpper(string(runes[0])) + string(runes[1:]))
+	}
+	goName := b.String()
+	if goName == "" || unicode.IsDigit([]rune(goName)[0]) {
+		goName = "Q" + goName
+	}
+	return goName
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+	"testing"
+
+	"github.com/kalli/sqlyac/queryfile"
+)
+
+func TestGenerateGoTypeChecks(t *testing.T) {
+	queries, _, err := parseSQL("example.sql")
+	if err != nil {
+		t.Fatalf("parseSQL failed: %v", err)
+	}
+	queries = append(queries, queryfile.Query{
+		Name:   "insert-event",
+		SQL:    "INSERT INTO events (`at`, url) VALUES (@happened_at, @url)",
+		Result: "execlastid",
+		Params: []queryfile.Param{{Name: "happened_at", Type: "timestamp"}, {Name: "url", Type: "text"}},
+	})
+	for _, result := range []string{"exec", "execrows", "execlastid"} {
+		sql := "UPDATE users SET active = @active WHERE id = @id; INSERT INTO log (id) VALUES (@id)"
+		queries = append(queries, queryfile.Query{
+			Name:       "two-statements-" + result,
+			SQL:        sql,
+			Statements: queryfile.SplitStatements(sql),
+			Result:     result,
+		})
+	}
+
+	for _, placeholder := range []string{"?", "$"} {
+		code, err := generateGo(queries, "queries", placeholder, "example.sql")
+		if err != nil {
+			t.Fatalf("generateGo failed: %v", err)
+		}
+
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, "queries.go", code, 0)
+		if err != nil {
+			t.Fatalf("generated code doesn't parse: %v\n%s", err, code)
+		}
+		conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+		if _, err := conf.Check("queries", fset, []*ast.File{file}, nil); err != nil {
+			t.Fatalf("generated code doesn't type check: %v\n%s", err, code)
+		}
+	}
+}
+
+func TestGenerateGoFunctions(t *testing.T) {
+	queries := []queryfile.Query{
+		{Name: "GetUser", SQL: "SELECT * FROM users WHERE id = @user_id", Result: "one",
+			Params: []queryfile.Param{{Name: "user_id", Type: "int"}}},
+		{Name: "ListUsers", SQL: "SELECT * FROM users"},
+		{Name: "DeleteUser", SQL: "DELETE FROM users WHERE id = @user_id OR parent_id = @user_id"},
+		{Name: "CountDeleted", SQL: "DELETE FROM users", Result: "execrows"},
+	}
+
+	code, err := generateGo(queries, "users", "?", "users.sql")
+	if err != nil {
+		t.Fatalf("generateGo failed: %v", err)
+	}
+
+	for _, expected := range []string{
+		"// Code generated by sqlyac from users.sql. DO NOT EDIT.",
+		"package users",
+		"const GetUserSQL = `SELECT * FROM users WHERE id = ?`",
+		"type GetUserParams struct {\n\tUserID int64\n}",
+		"func GetUser(ctx context.Context, db DBTX, arg GetUserParams) *sql.Row {",
+		"func ListUsers(ctx context.Context, db DBTX) (*sql.Rows, error) {",
+		"func DeleteUser(ctx context.Context, db DBTX, arg DeleteUserParams) (sql.Result, error) {",
+		"db.ExecContext(ctx, DeleteUserSQL, arg.UserID, arg.UserID)",
+		"func CountDeleted(ctx context.Context, db DBTX) (int64, error) {",
+		"return result.RowsAffected()",
+	} {
+		if !strings.Contains(code, expected) {
+			t.Errorf("expected generated code to contain %q, got:\n%s", expected, code)
+		}
+	}
+	if strings.Contains(code, `"time"`) {
+		t.Error("time should only be imported when it's used")
+	}
+}
+
+func TestGenerateGoSkipsStrings(t *testing.T) {
+	queries := []queryfile.Query{{Name: "Gmail", SQL: "SELECT * FROM users WHERE email LIKE '%@gmail.com' AND id = @id"}}
+
+	code, err := generateGo(queries, "users", "?", "users.sql")
+	if err != nil {
+		t.Fatalf("generateGo failed: %v", err)
+	}
+	if !strings.Contains(code, "type GmailParams struct {\n\tID any\n}") {
+		t.Errorf("expected only ID in the params, got:\n%s", code)
+	}
+}
+
+func TestGenerateGoStatements(t *testing.T) {
+	sql := "DELETE FROM orders WHERE user_id = @user_id;\nDELETE FROM users WHERE id = @user_id;"
+	queries := []queryfile.Query{{Name: "DeleteUser", SQL: sql, Statements: queryfile.SplitStatements(sql)}}
+
+	code, err := generateGo(queries, "users", "$", "users.sql")
+	if err != nil {
+		t.Fatalf("generateGo failed: %v", err)
+	}
+	for _, expected := range []string{
+		"var DeleteUserSQL = []string{\n\t`DELETE FROM orders WHERE user_id = $1`,\n\t`DELETE FROM users WHERE id = $1`,\n}",
+		"if _, err := db.ExecContext(ctx, DeleteUserSQL[0], arg.UserID); err != nil {",
+		"return db.ExecContext(ctx, DeleteUserSQL[1], arg.UserID)",
+	} {
+		if !strings.Contains(code, expected) {
+			t.Errorf("expected generated code to contain %q, got:\n%s", expected, code)
+		}
+	}
+
+	// rows can only come from one statement
+	queries[0].Result = "many"
+	if _, err := generateGo(queries, "users", "$", "users.sql"); err == nil || !strings.Contains(err.Error(), "2 statements") {
+		t.Errorf("expected an error for a query with two statements returning rows, got %v", err)
+	}
+}
+
+func TestGenerateGoNameCollisions(t *testing.T) {
+	testCases := []struct {
+		queries  []queryfile.Query
+		expected string
+	}{
+		{
+			[]queryfile.Query{{Name: "get_user", SQL: "SELECT 1"}, {Name: "GetUser", SQL: "SELECT 2"}},
+			"query get_user and query GetUser both generate GetUser",
+		},
+		{
+			[]queryfile.Query{{Name: "GetUser", SQL: "SELECT @id"}, {Name: "GetUserParams", SQL: "SELECT 2"}},
+			"query GetUser and query GetUserParams both generate GetUserParams",
+		},
+		{
+			[]queryfile.Query{{Name: "GetUser", SQL: "SELECT @user_id, @userID"}},
+			"params user_id and userID both become the field UserID",
+		},
+	}
+
+	for _, tc := range testCases {
+		_, err := generateGo(tc.queries, "queries", "?", "queries.sql")
+		if err == nil || !strings.Contains(err.Error(), tc.expected) {
+			t.Errorf("expected an error containing %q, got %v", tc.expected, err)
+		}
+	}
+}
+
+func TestGoName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"user_id":       "UserID",
+		"get-user":      "GetUser",
+		"GetAllUsers":   "GetAllUsers",
+		"api_url":       "APIURL",
+		"1st_place":     "Q1stPlace",
+		"lim":           "Lim",
+		"created_at_ts": "CreatedAtTs",
+	} {
+		if got := goName(name); got != expected {
+			t.Errorf("goName(%q) = %q, expected %q", name, got, expected)
+		}
+	}
+}
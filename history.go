@@ -0,0 +1,590 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kalli/sqlyac/queryfile"
+)
+
+// historyEntry is a line of ~/.sqlyac/history.jsonl, written every time a
+// query is printed or run
+type historyEntry struct {
+	Time  time.Time `json:"time"`
+	User  string    `json:"user"`
+	Host  string    `json:"host"`
+	File  string    `json:"file"`
+	Query string    `json:"query"`
+	// SQLHash is the sha256 of the interpolated sql, to tell whether two
+	// runs sent the same thing
+	SQLHash   string            `json:"sql_hash"`
+	Variables map[string]string `json:"variables,omitempty"`
+	Conn      string            `json:"conn,omitempty"`
+	// Mode is print, run, explain, explain analyze, dry-run or tx
+	Mode string `json:"mode"`
+	// Confirmation is yes, no, plan when the whole plan was confirmed at
+	// once, or not asked
+	Confirmation string `json:"confirmation"`
+	Status       string `json:"status"`
+	// RowsAffected is only known for writes run with a connection
+	RowsAffected *int64 `json:"rows_affected,omitempty"`
+	DurationMS   int64  `json:"duration_ms"`
+	// Invocation ties together the entries of one sqlyac command, Args are
+	// its arguments so `sqlyac !!` can run all of it again
+	Invocation string   `json:"invocation,omitempty"`
+	Args       []string `json:"args,omitempty"`
+}
+
+// historyLog appends entries to the history file, nil doesn't keep any
+type historyLog struct {
+	path       string
+	file       string
+	conn       string
+	user       string
+	host       string
+	invocation string
+	args       []string
+}
+
+// historyPath is where the history is kept
+func historyPath() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".sqlyac", "history.jsonl"), nil
+}
+
+// newHistoryLog returns a log for queries from file run with the named
+// connection, or nil when the config turns history off
+func newHistoryLog(config *Config, file, conn string) *historyLog {
+	if config.NoHistory {
+		return nil
+	}
+	path, err := historyPath()
+	if err != nil {
+		return nil
+	}
+	if file != "-" {
+		if abs, err := filepath.Abs(file); err == nil {
+			file = abs
+		}
+	}
+
+	h := &historyLog{path: path, file: file, conn: conn, user: os.Getenv("USER")}
+	if u, err := user.Current(); err == nil {
+		h.user = u.Username
+	}
+	h.host, _ = os.Hostname()
+	return h
+}
+
+// invoked starts an invocation, the entries recorded from now on are run
+// again together, by args after the file
+func (h *historyLog) invoked(args []string) {
+	if h == nil {
+		return
+	}
+	h.invocation = fmt.Sprintf("%d-%d", time.Now().UnixNano(), os.Getpid())
+	h.args = append([]string{h.file}, args...)
+}
+
+// invocationArgs are the arguments that run the same queries the same way
+// again. --yes-i-mean-prod is left out so a rerun asks again.
+func invocationArgs(names []string, all bool, tag string, noDeps bool, connName, dialect string, vars map[string]string, opts runOptions) []string {
+	args := append([]string{}, names...)
+	if all {
+		args = append(args, "--all")
+	}
+	if tag != "" {
+		args = append(args, "--tag", tag)
+	}
+	args = append(args, sortedVarArgs(vars)...)
+	if connName != "" {
+		args = append(args, "--conn", connName)
+	}
+	if dialect != "" {
+		args = append(args, "--dialect", dialect)
+	}
+	for _, flag := range []struct {
+		set  bool
+		name string
+	}{
+		{opts.confirm, "--confirm"},
+		{opts.explain, "--explain"},
+		{opts.analyze, "--analyze"},
+		{opts.dryRun, "--dry-run"},
+		{opts.tx, "--tx"},
+		{noDeps, "--no-deps"},
+	} {
+		if flag.set {
+			args = append(args, flag.name)
+		}
+	}
+	return args
+}
+
+// sortedVarArgs turns variables into --var arguments in a stable order
+func sortedVarArgs(vars map[string]string) []string {
+	var names []string
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var args []string
+	for _, name := range names {
+		args = append(args, "--var", name+"="+vars[name])
+	}
+	return args
+}
+
+// record fills in who, where and when and appends the entry. not being able
+// to write the history shouldn't stop anyone, so errors are only reported.
+func (h *historyLog) record(entry historyEntry) {
+	if h == nil {
+		return
+	}
+	entry.Time = time.Now().UTC()
+	entry.User, entry.Host, entry.File, entry.Conn = h.user, h.host, h.file, h.conn
+	entry.Invocation, entry.Args = h.invocation, h.args
+
+	data, err := json.Marshal(entry)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(h.path), 0700)
+	}
+	if err == nil {
+		var f *os.File
+		// variables can be sensitive, keep the history to ourselves
+		if f, err = os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600); err == nil {
+			_, err = f.Write(append(data, '\n'))
+			f.Close()
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: can't write history: %v\n", err)
+	}
+}
+
+// sqlHash is the hash of the sql that went out
+func sqlHash(sql string) string {
+	sum := sha256.Sum256([]byte(sql))
+	return hex.EncodeToString(sum[:])
+}
+
+// usedVariables returns the values of the variables the query references
+func usedVariables(q queryfile.Query, variables map[string]string) map[string]string {
+	used := make(map[string]string)
+	for _, name := range queryfile.ReferencedVariables(q.SQL) {
+		if value, ok := variables[name]; ok {
+			used[name] = value
+		}
+	}
+	if len(used) == 0 {
+		return nil
+	}
+	return used
+}
+
+// rowsAffectedMarker is printed with the rows affected by each write so they
+// can be picked out of the client's output
+const rowsAffectedMarker = "sqlyac_rows_affected"
+
+var rowsAffectedRegex = regexp.MustCompile(rowsAffectedMarker + `=(-?\d+)`)
+
+// countRowsSQL has the client print the rows affected by every write in sql,
+// it returns sql unchanged when there are no writes or the statements can't
+// be split safely
+func countRowsSQL(sql, dialect string) string {
+	marker := map[string]string{
+		"mysql":    "SELECT CONCAT('" + rowsAffectedMarker + "=', ROW_COUNT()) AS " + rowsAffectedMarker + ";",
+		"sqlite":   "SELECT '" + rowsAffectedMarker + "=' || changes();",
+		"postgres": `\echo ` + rowsAffectedMarker + "=:ROW_COUNT",
+	}[dialect]
+	if marker == "" || strings.Contains(strings.ToUpper(sql), "DELIMITER") {
+		return sql
+	}
+
+	var lines []string
+	writes := false
+	for _, statement := range queryfile.SplitStatements(sql) {
+		lines = append(lines, statement+";")
+		if queryfile.Kind(statement) == queryfile.KindWrite {
+			lines = append(lines, marker)
+			writes = true
+		}
+	}
+	if !writes {
+		return sql
+	}
+	return strings.Join(lines, "\n")
+}
+
+// rowCounter passes client output on without the lines countRowsSQL added,
+// and adds up the rows affected they report
+type rowCounter struct {
+	w       io.Writer
+	partial []byte
+	rows    int64
+	counted bool
+}
+
+func (c *rowCounter) Write(p []byte) (int, error) {
+	c.partial = append(c.partial, p...)
+	for {
+		i := bytes.IndexByte(c.partial, '\n')
+		if i == -1 {
+			return len(p), nil
+		}
+		line := c.partial[:i+1]
+		if err := c.line(line); err != nil {
+			return len(p), err
+		}
+		c.partial = c.partial[i+1:]
+	}
+}
+
+func (c *rowCounter) line(line []byte) error {
+	if !bytes.Contains(line, []byte(rowsAffectedMarker)) {
+		_, err := c.w.Write(line)
+		return err
+	}
+	if m := rowsAffectedRegex.FindSubmatch(line); m != nil {
+		n, _ := strconv.ParseInt(string(m[1]), 10, 64)
+		c.rows += n
+		c.counted = true
+	}
+	return nil
+}
+
+// flush writes what's left of the output when it doesn't end in a newline
+func (c *rowCounter) flush() error {
+	if len(c.partial) == 0 {
+		return nil
+	}
+	err := c.line(c.partial)
+	c.partial = nil
+	return err
+}
+
+// rowsAffected returns the rows affected, nil when there were no writes
+func (c *rowCounter) rowsAffected() *int64 {
+	if !c.counted {
+		return nil
+	}
+	rows := c.rows
+	return &rows
+}
+
+func runHistory(args []string) {
+	var query, file, conn, userName, since string
+	var limit, rerun int
+	var asJSON bool
+	vars := varFlags{}
+
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	fs.StringVar(&query, "query", "", "only show runs of this query")
+	fs.StringVar(&file, "file", "", "only show runs from this query file")
+	fs.StringVar(&conn, "conn", "", "only show runs with this connection")
+	fs.StringVar(&userName, "user", "", "only show runs by this user")
+	fs.StringVar(&since, "since", "", "only show runs in this long, like 24h")
+	fs.IntVar(&limit, "limit", 20, "show this many of the latest runs, 0 shows all of them")
+	fs.BoolVar(&asJSON, "json", false, "print the entries as json lines to stdout")
+	fs.IntVar(&rerun, "rerun", 0, "run the entry with this number again")
+	fs.Var(vars, "var", "with --rerun, change a variable (name=value, repeatable)")
+	positional := parseArgs(fs, args)
+
+	path, err := historyPath()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	entries, err := readHistory(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading history: %v\n", err)
+		os.Exit(1)
+	}
+
+	if rerun != 0 {
+		if rerun < 0 || rerun > len(entries) {
+			fmt.Fprintf(os.Stderr, "error: there is no history entry %d\n", rerun)
+			os.Exit(1)
+		}
+		os.Exit(rerunEntry(entries[rerun-1], vars))
+	}
+
+	filter := historyFilter{
+		search: strings.ToLower(strings.Join(positional, " ")),
+		query:  query,
+		file:   file,
+		conn:   conn,
+		user:   userName,
+	}
+	if since != "" {
+		d, err := time.ParseDuration(since)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: invalid --since: %v\n", err)
+			os.Exit(1)
+		}
+		filter.after = time.Now().Add(-d)
+	}
+
+	// entries are numbered by their line in the history so --rerun can find them
+	var numbers []int
+	for i, e := range entries {
+		if filter.matches(e) {
+			numbers = append(numbers, i+1)
+		}
+	}
+	if limit > 0 && len(numbers) > limit {
+		numbers = numbers[len(numbers)-limit:]
+	}
+
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		for _, n := range numbers {
+			if err := encoder.Encode(entries[n-1]); err != nil {
+				fmt.Fprintf(os.Stderr, "error writing json: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		return
+	}
+
+	// humans get an aligned table on stderr, like sqlyac list
+	w := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "#\tTIME\tUSER\tQUERY\tFILE\tCONN\tMODE\tCONFIRMED\tSTATUS\tROWS\n")
+	for _, n := range numbers {
+		e := entries[n-1]
+		rows := ""
+		if e.RowsAffected != nil {
+			rows = strconv.FormatInt(*e.RowsAffected, 10)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			n, e.Time.Local().Format("2006-01-02 15:04:05"), e.User, e.Query, displayPath(e.File),
+			e.Conn, e.Mode, e.Confirmation, e.Status, rows)
+	}
+	w.Flush()
+}
+
+// historyFilter picks the history entries to show
+type historyFilter struct {
+	search                  string
+	query, file, conn, user string
+	after                   time.Time
+}
+
+func (f historyFilter) matches(e historyEntry) bool {
+	switch {
+	case f.query != "" && !strings.EqualFold(e.Query, f.query):
+		return false
+	case f.file != "" && e.File != f.file && displayPath(e.File) != f.file && filepath.Base(e.File) != f.file:
+		return false
+	case f.conn != "" && e.Conn != f.conn:
+		return false
+	case f.user != "" && e.User != f.user:
+		return false
+	case !f.after.IsZero() && e.Time.Before(f.after):
+		return false
+	}
+	if f.search == "" {
+		return true
+	}
+	text := strings.ToLower(strings.Join([]string{e.Query, e.File, e.User, e.Host, e.Conn, e.Mode, e.Status, e.SQLHash}, " "))
+	return strings.Contains(text, f.search)
+}
+
+// readHistory reads the entries in the history file, there are none when it
+// doesn't exist yet. lines that aren't entries, like one cut short by a full
+// disk, are skipped with a warning so the rest of the history still works.
+func readHistory(path string) ([]historyEntry, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []historyEntry
+	for i, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		var e historyEntry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: skipping line %d of %s: %v\n", i+1, path, err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// displayPath shortens paths in the current directory
+func displayPath(path string) string {
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return rel
+		}
+	}
+	return path
+}
+
+// rerunArgs are the arguments that run an entry again, with some of its
+// variables changed
+func rerunArgs(e historyEntry, vars map[string]string) ([]string, error) {
+	if e.File == "-" {
+		return nil, fmt.Errorf("%s was read from stdin, it can't be run again", e.Query)
+	}
+
+	args := []string{e.File, e.Query}
+	values := make(map[string]string)
+	for name, value := range e.Variables {
+		values[name] = value
+	}
+	for name, value := range vars {
+		values[name] = value
+	}
+	args = append(args, sortedVarArgs(values)...)
+
+	if e.Conn != "" {
+		args = append(args, "--conn", e.Conn)
+	}
+	switch e.Mode {
+	case "explain":
+		args = append(args, "--explain")
+	case "explain analyze":
+		args = append(args, "--explain", "--analyze")
+	case "dry-run":
+		args = append(args, "--dry-run")
+	case "tx":
+		args = append(args, "--tx")
+	}
+	// only the entry runs again, not the queries it depends on
+	return append(args, "--no-deps"), nil
+}
+
+// lastRun returns the latest entry that ran, queries that were skipped or
+// blocked never did
+func lastRun(entries []historyEntry) (historyEntry, bool) {
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Status != "skipped" && entries[i].Status != "blocked" {
+			return entries[i], true
+		}
+	}
+	return historyEntry{}, false
+}
+
+// lastArgs are the arguments that run the invocation of an entry again, with
+// some of its variables changed. entries from before invocations were
+// recorded only run themselves.
+func lastArgs(e historyEntry, vars map[string]string) ([]string, error) {
+	if len(e.Args) == 0 {
+		return rerunArgs(e, vars)
+	}
+	if e.Args[0] == "-" {
+		return nil, fmt.Errorf("%s was read from stdin, it can't be run again", e.Query)
+	}
+	// leave out the variables that get a new value
+	var args []string
+	for i := 0; i < len(e.Args); i++ {
+		if e.Args[i] == "--var" && i+1 < len(e.Args) {
+			name, _, _ := strings.Cut(e.Args[i+1], "=")
+			if _, ok := vars[name]; ok {
+				i++
+				continue
+			}
+		}
+		args = append(args, e.Args[i])
+	}
+	return append(args, sortedVarArgs(vars)...), nil
+}
+
+// checkLastArgs makes sure --last only comes with variables, everything
+// else is taken from the history
+func checkLastArgs(fs *flag.FlagSet, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("--last runs the previous query again, it doesn't take a file or query names")
+	}
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		if err == nil && f.Name != "last" && f.Name != "var" {
+			err = fmt.Errorf("--last only takes --var, use sqlyac history --rerun for anything else")
+		}
+	})
+	return err
+}
+
+// runLast runs the latest command in the history that ran anything again,
+// with all its queries and flags, and returns the exit code
+func runLast(vars map[string]string) int {
+	path, err := historyPath()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	entries, err := readHistory(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading history: %v\n", err)
+		return 1
+	}
+	e, ok := lastRun(entries)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "error: there's nothing in the history to run again\n")
+		return 1
+	}
+	args, err := lastArgs(e, vars)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	return rerun(args)
+}
+
+// rerunEntry runs an entry again and returns the exit code
+func rerunEntry(e historyEntry, vars map[string]string) int {
+	args, err := rerunArgs(e, vars)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	return rerun(args)
+}
+
+// rerun runs sqlyac again with args and returns its exit code. it asks for
+// confirmation just like the first time.
+func rerun(args []string) int {
+	self, err := os.Executable()
+	if err == nil {
+		fmt.Fprintf(os.Stderr, "sqlyac %s\n", strings.Join(args, " "))
+		cmd := exec.Command(self, args...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		err = cmd.Run()
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	return 0
+}
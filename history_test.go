@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kalli/sqlyac/queryfile"
+)
+
+func TestCountRowsSQL(t *testing.T) {
+	sql := "UPDATE users SET active = 0;\nSELECT COUNT(*) FROM users;\nDELETE FROM users WHERE id = 3;"
+	expected := "UPDATE users SET active = 0;\nSELECT 'sqlyac_rows_affected=' || changes();\nSELECT COUNT(*) FROM users;\n" +
+		"DELETE FROM users WHERE id = 3;\nSELECT 'sqlyac_rows_affected=' || changes();"
+	if got := countRowsSQL(sql, "sqlite"); got != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+
+	// nothing to count
+	for _, tc := range []struct{ sql, dialect string }{
+		{"SELECT * FROM users;", "sqlite"},
+		{"CREATE TABLE t (id INT);", "mysql"},
+		{"DELIMITER $$\nCREATE PROCEDURE p() BEGIN UPDATE t SET a = 1; END$$\nDELIMITER ;", "mysql"},
+		{"UPDATE users SET active = 0;", "oracle"},
+	} {
+		if got := countRowsSQL(tc.sql, tc.dialect); got != tc.sql {
+			t.Errorf("expected %q to be left alone, got %q", tc.sql, got)
+		}
+	}
+}
+
+func TestRowCounter(t *testing.T) {
+	var out bytes.Buffer
+	c := &rowCounter{w: &out}
+	c.Write([]byte("3\nsqlyac_rows_affected\nsqlyac_rows_"))
+	c.Write([]byte("affected=2\nbob\nsqlyac_rows_affected=5\nlast"))
+	c.flush()
+
+	if out.String() != "3\nbob\nlast" {
+		t.Errorf("unexpected output %q", out.String())
+	}
+	if rows := c.rowsAffected(); rows == nil || *rows != 7 {
+		t.Errorf("expected 7 rows affected, got %v", rows)
+	}
+	if rows := (&rowCounter{}).rowsAffected(); rows != nil {
+		t.Errorf("expected no rows affected without writes, got %d", *rows)
+	}
+}
+
+func TestHistoryLog(t *testing.T) {
+	h := &historyLog{path: filepath.Join(t.TempDir(), ".sqlyac", "history.jsonl"), file: "/work/example.sql", conn: "local", user: "kalli", host: "laptop"}
+	h.record(historyEntry{Query: "GetUser", Mode: "run", Status: "ok"})
+	h.record(historyEntry{Query: "DeleteUser", Mode: "run", Status: "skipped", Confirmation: "no"})
+	var disabled *historyLog
+	disabled.record(historyEntry{Query: "Nothing"})
+
+	entries, err := readHistory(h.path)
+	if err != nil {
+		t.Fatalf("readHistory failed: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	e := entries[1]
+	if e.Query != "DeleteUser" || e.User != "kalli" || e.Host != "laptop" || e.File != "/work/example.sql" || e.Conn != "local" || e.Time.IsZero() {
+		t.Errorf("unexpected entry %+v", e)
+	}
+
+	if entries, err := readHistory(filepath.Join(t.TempDir(), "missing.jsonl")); err != nil || entries != nil {
+		t.Errorf("expected no entries for a missing history, got %v %v", entries, err)
+	}
+
+	// a corrupt line doesn't take the rest of the history with it
+	f, err := os.OpenFile(h.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"query": "Trunc` + "\n")
+	f.Close()
+	h.record(historyEntry{Query: "GetOrders", Mode: "run", Status: "ok"})
+	entries, err = readHistory(h.path)
+	if err != nil || len(entries) != 3 || entries[2].Query != "GetOrders" {
+		t.Errorf("expected the 3 good entries, got %v %v", entries, err)
+	}
+}
+
+func TestRunQueriesRecordsHistory(t *testing.T) {
+	originalInput := promptInput
+	defer func() { promptInput = originalInput }()
+	promptInput = strings.NewReader("n\n")
+
+	h := &historyLog{path: filepath.Join(t.TempDir(), "history.jsonl")}
+	queries := []queryfile.Query{
+		{Name: "GetUser", SQL: "SELECT * FROM users WHERE id = @id"},
+		{Name: "DropUsers", SQL: "DROP TABLE users"},
+	}
+	opts := runOptions{history: h}
+	_, err := runQueries(queries, map[string]string{"id": "5", "unused": "1"}, &Config{ConfirmSchemaChanges: true}, opts, &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("runQueries failed: %v", err)
+	}
+
+	entries, err := readHistory(h.path)
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %v %v", entries, err)
+	}
+	expected := historyEntry{
+		Query:        "GetUser",
+		SQLHash:      sqlHash("SELECT * FROM users WHERE id = 5"),
+		Variables:    map[string]string{"id": "5"},
+		Mode:         "print",
+		Confirmation: "not asked",
+		Status:       "ok",
+	}
+	got := entries[0]
+	got.Time = time.Time{}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+	if entries[1].Confirmation != "no" || entries[1].Status != "skipped" {
+		t.Errorf("expected the drop to be skipped, got %+v", entries[1])
+	}
+}
+
+func TestRunQueriesRecordsRowsAffected(t *testing.T) {
+	conn, query := sqliteConnection(t)
+	h := &historyLog{path: filepath.Join(t.TempDir(), "history.jsonl")}
+	opts := runOptions{conn: &conn, dialect: "sqlite", history: h}
+
+	queries := []queryfile.Query{{Name: "Deactivate", SQL: "UPDATE users SET active = 0 WHERE active = 1;\nSELECT COUNT(*) FROM users;"}}
+	var out bytes.Buffer
+	if _, err := runQueries(queries, nil, &Config{}, opts, &out); err != nil {
+		t.Fatalf("runQueries failed: %v", err)
+	}
+	if out.String() != "3\n" {
+		t.Errorf("expected only the query's output, got %q", out.String())
+	}
+	if got := query("SELECT COUNT(*) FROM users WHERE active = 1;"); got != "0" {
+		t.Errorf("expected the update to run, got %s active users", got)
+	}
+
+	entries, err := readHistory(h.path)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %v %v", entries, err)
+	}
+	if rows := entries[0].RowsAffected; rows == nil || *rows != 2 {
+		t.Errorf("expected 2 rows affected, got %v", rows)
+	}
+}
+
+func TestHistoryFilter(t *testing.T) {
+	now := time.Now()
+	e := historyEntry{Time: now.Add(-2 * time.Hour), User: "kalli", Query: "DeleteOldOrders", File: "/work/orders.sql", Conn: "prod", Mode: "run", Status: "ok"}
+
+	testCases := []struct {
+		filter   historyFilter
+		expected bool
+	}{
+		{historyFilter{}, true},
+		{historyFilter{search: "delete"}, true},
+		{historyFilter{search: "prod"}, true},
+		{historyFilter{search: "insert"}, false},
+		{historyFilter{query: "deleteoldorders"}, true},
+		{historyFilter{file: "orders.sql"}, true},
+		{historyFilter{file: "users.sql"}, false},
+		{historyFilter{conn: "local"}, false},
+		{historyFilter{user: "kalli"}, true},
+		{historyFilter{after: now.Add(-time.Hour)}, false},
+		{historyFilter{after: now.Add(-3 * time.Hour)}, true},
+	}
+
+	for _, tc := range testCases {
+		if got := tc.filter.matches(e); got != tc.expected {
+			t.Errorf("%+v: expected %v, got %v", tc.filter, tc.expected, got)
+		}
+	}
+}
+
+func TestRerunArgs(t *testing.T) {
+	e := historyEntry{
+		File:      "/work/example.sql",
+		Query:     "QueryWithVariables",
+		Variables: map[string]string{"user_id": "2", "status": `"completed"`},
+		Conn:      "local",
+		Mode:      "explain analyze",
+	}
+
+	args, err := rerunArgs(e, map[string]string{"user_id": "5"})
+	if err != nil {
+		t.Fatalf("rerunArgs failed: %v", err)
+	}
+	expected := []string{"/work/example.sql", "QueryWithVariables", "--var", `status="completed"`, "--var", "user_id=5", "--conn", "local", "--explain", "--analyze", "--no-deps"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("expected %q, got %q", expected, args)
+	}
+
+	if _, err := rerunArgs(historyEntry{File: "-", Query: "GetUser"}, nil); err == nil {
+		t.Error("expected an error for a file read from stdin, got none")
+	}
+}
+
+func TestInvocationArgs(t *testing.T) {
+	opts := runOptions{tx: true, yesIMeanProd: true}
+	args := invocationArgs([]string{"InsertSampleUsers", "InsertSampleOrders"}, false, "", true, "prod", "", map[string]string{"status": "'new'", "id": "5"}, opts)
+	expected := []string{"InsertSampleUsers", "InsertSampleOrders", "--var", "id=5", "--var", "status='new'", "--conn", "prod", "--tx", "--no-deps"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("expected %q, got %q", expected, args)
+	}
+
+	args = invocationArgs(nil, false, "setup", false, "", "sqlite", nil, runOptions{explain: true, analyze: true})
+	expected = []string{"--tag", "setup", "--dialect", "sqlite", "--explain", "--analyze"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("expected %q, got %q", expected, args)
+	}
+}
+
+func TestLastRun(t *testing.T) {
+	h := &historyLog{path: filepath.Join(t.TempDir(), "history.jsonl"), file: "/work/example.sql", conn: "local"}
+	h.invoked([]string{"--tag", "setup", "--var", "id=5", "--var", "name='bob'", "--conn", "local", "--no-deps"})
+	h.record(historyEntry{Query: "CreateUsers", Status: "ok"})
+	h.record(historyEntry{Query: "InsertSampleUsers", Status: "ok"})
+	first := h.invocation
+	// a later command that never ran anything
+	h.invoked([]string{"DropUsers", "--conn", "local"})
+	h.record(historyEntry{Query: "DropUsers", Status: "skipped"})
+	h.invoked([]string{"TruncateUsers", "--conn", "local"})
+	h.record(historyEntry{Query: "TruncateUsers", Status: "blocked"})
+
+	entries, err := readHistory(h.path)
+	if err != nil {
+		t.Fatalf("readHistory failed: %v", err)
+	}
+	e, ok := lastRun(entries)
+	if !ok || e.Invocation != first {
+		t.Fatalf("expected the setup invocation, got %+v", e)
+	}
+
+	// the whole invocation runs again with its flags, --var replaces a variable
+	args, err := lastArgs(e, map[string]string{"id": "7"})
+	if err != nil {
+		t.Fatalf("lastArgs failed: %v", err)
+	}
+	expected := []string{"/work/example.sql", "--tag", "setup", "--var", "name='bob'", "--conn", "local", "--no-deps", "--var", "id=7"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("expected %q, got %q", expected, args)
+	}
+
+	// entries from before invocations were recorded run on their own
+	args, err = lastArgs(historyEntry{File: "/work/example.sql", Query: "GetUser", Mode: "run"}, nil)
+	if err != nil || !reflect.DeepEqual(args, []string{"/work/example.sql", "GetUser", "--no-deps"}) {
+		t.Errorf("unexpected args %q %v", args, err)
+	}
+
+	if _, ok := lastRun(entries[2:]); ok {
+		t.Error("expected nothing to run again when nothing ran")
+	}
+}
+
+func TestCheckLastArgs(t *testing.T) {
+	testCases := []struct {
+		args  []string
+		valid bool
+	}{
+		{[]string{"--last"}, true},
+		{[]string{"--last", "--var", "id=5"}, true},
+		{[]string{"--last", "example.sql"}, false},
+		{[]string{"--last", "--explain"}, false},
+	}
+
+	for _, tc := range testCases {
+		fs := flag.NewFlagSet("sqlyac", flag.ContinueOnError)
+		fs.Bool("last", false, "")
+		fs.Bool("explain", false, "")
+		fs.Var(varFlags{}, "var", "")
+		positional := parseArgs(fs, tc.args)
+		if err := checkLastArgs(fs, positional); (err == nil) != tc.valid {
+			t.Errorf("%q: expected valid %v, got %v", tc.args, tc.valid, err)
+		}
+	}
+}
+
+func TestRunLastWithoutHistory(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	if code := runLast(nil); code != 1 {
+		t.Errorf("expected exit code 1 without a history, got %d", code)
+	}
+}
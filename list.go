@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/kalli/sqlyac/queryfile"
+)
+
+// listEntry is what `sqlyac list --json` prints for each query
+type listEntry struct {
+	Name        string              `json:"name"`
+	Description string              `json:"description,omitempty"`
+	Tags        []string            `json:"tags"`
+	Params      []queryfile.Param   `json:"params"`
+	Depends     []string            `json:"depends,omitempty"`
+	Captures    []queryfile.Capture `json:"captures,omitempty"`
+	Kind        string              `json:"kind"`
+	Result      string              `json:"result,omitempty"`
+	File        string              `json:"file"`
+	Line        int                 `json:"line"`
+}
+
+func runList(args []string) {
+	var tag, grep, kind string
+	var asJSON bool
+
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	fs.StringVar(&tag, "tag", "", "only list queries with this tag")
+	fs.StringVar(&grep, "grep", "", "only list queries whose name or description matches this regex")
+	fs.StringVar(&kind, "kind", "", "only list queries of this kind (read, write or ddl)")
+	fs.BoolVar(&asJSON, "json", false, "print queries as json to stdout")
+	positional := parseArgs(fs, args)
+
+	if len(positional) != 1 {
+		fmt.Fprintf(os.Stderr, "usage: sqlyac list <filepath> [--tag <tag>] [--grep <regex>] [--kind read|write|ddl] [--json]\n")
+		os.Exit(1)
+	}
+	if kind != "" && kind != "read" && kind != "write" && kind != "ddl" {
+		fmt.Fprintf(os.Stderr, "error: --kind must be one of read, write or ddl\n")
+		os.Exit(1)
+	}
+
+	var grepRegex *regexp.Regexp
+	if grep != "" {
+		var err error
+		grepRegex, err = regexp.Compile("(?i)" + grep)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: invalid --grep pattern: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	queries, _, err := parseSQLWithOptions(positional[0], loadConfigOrDefaults().parseOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error parsing sql: %v\n", err)
+		os.Exit(1)
+	}
+
+	entries := []listEntry{}
+	for _, q := range queries {
+		entry := newListEntry(q)
+		if tag != "" && !hasTag(q, tag) {
+			continue
+		}
+		if kind != "" && entry.Kind != kind {
+			continue
+		}
+		if grepRegex != nil && !grepRegex.MatchString(q.Name) && !grepRegex.MatchString(q.Description) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(entries); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing json: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// humans get an aligned table on stderr, same as the plain listing
+	w := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "NAME\tKIND\tLOCATION\tTAGS\tPARAMS\tDESCRIPTION\n")
+	for _, e := range entries {
+		var params []string
+		for _, p := range e.Params {
+			params = append(params, p.Name)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s:%d\t%s\t%s\t%s\n",
+			e.Name, e.Kind, e.File, e.Line,
+			strings.Join(e.Tags, ","), strings.Join(params, ","), e.Description)
+	}
+	w.Flush()
+}
+
+func newListEntry(q queryfile.Query) listEntry {
+	tags := q.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	return listEntry{
+		Name:        q.Name,
+		Description: q.Description,
+		Tags:        tags,
+		Params:      q.AllParams(),
+		Depends:     q.Depends,
+		Captures:    q.Captures,
+		Kind:        queryfile.Kind(q.SQL),
+		Result:      q.Result,
+		File:        q.File,
+		Line:        q.Line,
+	}
+}
+
+func hasTag(q queryfile.Query, tag string) bool {
+	for _, t := range q.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/kalli/sqlyac/queryfile"
+)
+
+func TestNewListEntry(t *testing.T) {
+	q := queryfile.Query{
+		Name: "CleanupTestData",
+		SQL:  "DROP TABLE IF EXISTS orders;",
+		File: "example.sql",
+		Line: 12,
+	}
+
+	entry := newListEntry(q)
+	if entry.Kind != "ddl" {
+		t.Errorf("expected kind ddl, got %s", entry.Kind)
+	}
+	// editor plugins should always get arrays, never null
+	if entry.Tags == nil || entry.Params == nil {
+		t.Errorf("expected empty tags and params, got %v and %v", entry.Tags, entry.Params)
+	}
+	if entry.File != "example.sql" || entry.Line != 12 {
+		t.Errorf("unexpected location %s:%d", entry.File, entry.Line)
+	}
+}
+
+func TestHasTag(t *testing.T) {
+	q := queryfile.Query{Tags: []string{"setup", "Fixtures"}}
+	if !hasTag(q, "fixtures") {
+		t.Error("tags should match case-insensitively")
+	}
+	if hasTag(q, "reports") {
+		t.Error("unexpected tag match")
+	}
+}
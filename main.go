@@ -1,73 +1,200 @@
 package main
 
 import (
-	"bufio"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
+
+	"github.com/kalli/sqlyac/queryfile"
 )
 
-type Query struct {
-	Name string
-	SQL  string
+type Config struct {
+	Confirm              bool     `json:"confirm"`
+	ConfirmSchemaChanges bool     `json:"confirm_schema_changes"`
+	ConfirmUpdates       bool     `json:"confirm_updates"`
+	Extensions           []string `json:"extensions"`
+	KeepComments         bool     `json:"keep_comments"`
+	Syntax               string   `json:"syntax"`
+	Dialect              string   `json:"dialect"`
+	// Connections are the database clients --conn can run queries with
+	Connections map[string]Connection `json:"connections"`
+	// NoHistory stops sqlyac from keeping ~/.sqlyac/history.jsonl
+	NoHistory bool `json:"no_history"`
+	// BlockUnbounded refuses to run updates and deletes without a WHERE,
+	// truncates and drops unless the query has `-- @allow-unbounded`
+	BlockUnbounded bool `json:"block_unbounded"`
 }
 
-type Config struct {
-	Confirm              bool `json:"confirm"`
-	ConfirmSchemaChanges bool `json:"confirm_schema_changes"`
-	ConfirmUpdates       bool `json:"confirm_updates"`
+// parseOptions returns the parse options set in the config
+func (c *Config) parseOptions() queryfile.Options {
+	return queryfile.Options{KeepComments: c.KeepComments, Syntax: c.Syntax}
+}
+
+// defaultExtensions are the query file extensions accepted when the config doesn't list any
+var defaultExtensions = []string{".sql", ".mysql", ".pgsql", ".psql"}
+
+// promptInput is where confirmation answers are read from
+var promptInput io.Reader = os.Stdin
+
+// varFlags collects repeated `--var name=value` flags
+type varFlags map[string]string
+
+func (v varFlags) String() string {
+	var pairs []string
+	for name, value := range v {
+		pairs = append(pairs, name+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (v varFlags) Set(s string) error {
+	name, value, ok := strings.Cut(s, "=")
+	name = strings.TrimPrefix(strings.TrimSpace(name), "@")
+	if !ok || name == "" {
+		return fmt.Errorf("expected name=value, got %q", s)
+	}
+	v[name] = value
+	return nil
 }
 
 func main() {
+	// subcommands get their own flags
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "list":
+			runList(os.Args[2:])
+			return
+		case "convert":
+			runConvert(os.Args[2:])
+			return
+		case "gen":
+			runGen(os.Args[2:])
+			return
+		case "test":
+			runTest(os.Args[2:])
+			return
+		case "snapshot":
+			runSnapshot(os.Args[2:])
+			return
+		case "history":
+			runHistory(os.Args[2:])
+			return
+		case "completion":
+			runCompletion(os.Args[2:])
+			return
+		case "__complete":
+			runComplete(os.Args[2:])
+			return
+		}
+	}
+
 	var filepath string
 	var queryName string
 	var confirm bool
+	var dialectFlag string
+	var explain, analyze, dryRun, tx bool
+	var connName string
+	var all bool
+	var tag string
+	var noDeps bool
+	var last bool
+	var yesIMeanProd bool
+	vars := varFlags{}
 
 	flag.StringVar(&filepath, "file", "", "path to sql file")
 	flag.StringVar(&queryName, "name", "", "name of query to extract")
 	flag.BoolVar(&confirm, "confirm", false, "prompt for confirmation before executing query (overrides config)")
-	flag.Parse()
-	// load config
-	config, err := loadConfig()
-	if err != nil {
-		// if config doesn't exist, use defaults
-		config = &Config{
-			Confirm:              false,
-			ConfirmSchemaChanges: true,
-			ConfirmUpdates:       true,
+	flag.Var(vars, "var", "set a variable, overriding the file (name=value, repeatable)")
+	flag.StringVar(&dialectFlag, "dialect", "", "sql dialect for --explain and --dry-run (mysql, postgres or sqlite)")
+	flag.BoolVar(&explain, "explain", false, "output the query plan instead of running the query")
+	flag.BoolVar(&analyze, "analyze", false, "with --explain, use EXPLAIN ANALYZE which runs the query (always asks for confirmation)")
+	flag.BoolVar(&dryRun, "dry-run", false, "run the query in a transaction that is rolled back and report rows affected")
+	flag.BoolVar(&tx, "tx", false, "run the query in a transaction, with --conn you're asked whether to commit")
+	flag.StringVar(&connName, "conn", "", "run the query with a connection from the config instead of printing it")
+	flag.BoolVar(&all, "all", false, "run every query in the file")
+	flag.StringVar(&tag, "tag", "", "run every query with this tag")
+	flag.BoolVar(&noDeps, "no-deps", false, "don't run the queries listed in @depends, they already ran")
+	flag.BoolVar(&yesIMeanProd, "yes-i-mean-prod", false, "run on a protected connection without a terminal to confirm on, saying yes to everything")
+	flag.BoolVar(&last, "last", false, "run the previous query from the history again, --var changes its variables")
+	// handle positional args too bc that's more ergonomic
+	args := parseArgs(flag.CommandLine, os.Args[1:])
+
+	// sqlyac !! is the same as sqlyac --last
+	if len(args) > 0 && args[0] == "!!" {
+		last, args = true, args[1:]
+	}
+	if last {
+		if err := checkLastArgs(flag.CommandLine, args); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
 		}
+		os.Exit(runLast(vars))
 	}
 
-	// handle positional args too bc that's more ergonomic
-	args := flag.Args()
+	config := loadConfigOrDefaults()
+
 	if filepath == "" && len(args) > 0 {
-		filepath = args[0]
+		filepath, args = args[0], args[1:]
 	}
-	if queryName == "" && len(args) > 1 {
-		queryName = args[1]
+	// every other positional arg is a query name
+	names := args
+	if queryName != "" {
+		names = append([]string{queryName}, names...)
 	}
 
 	if filepath == "" {
-		fmt.Fprintf(os.Stderr, "usage: sqlyac <filepath> [--name <queryname>]\n")
+		fmt.Fprintf(os.Stderr, "usage: sqlyac <filepath> [<queryname>... | --all | --tag <tag>] [--var name=value] [--explain [--analyze] | --dry-run | --tx] [--dialect mysql|postgres|sqlite] [--conn <name> [--yes-i-mean-prod]] [--no-deps]\n")
+		fmt.Fprintf(os.Stderr, "       sqlyac !! | --last [--var name=value]\n")
+		fmt.Fprintf(os.Stderr, "       sqlyac list <filepath> [--tag <tag>] [--grep <regex>] [--kind read|write|ddl] [--json]\n")
+		fmt.Fprintf(os.Stderr, "       sqlyac convert --from <format> --to <format> <filepath>\n")
+		fmt.Fprintf(os.Stderr, "       sqlyac test <filepath> [<queryname>...] [--tag <tag>] [--conn <name> [--allow-writes] [--yes-i-mean-prod]] [--format tap|junit]\n")
+		fmt.Fprintf(os.Stderr, "       sqlyac snapshot <filepath> [<queryname>...] [--tag <tag>] [--conn <name> [--allow-writes] [--yes-i-mean-prod]] [--verify]\n")
+		fmt.Fprintf(os.Stderr, "       sqlyac history [<search>] [--query <name>] [--file <path>] [--conn <name>] [--user <name>] [--since <duration>] [--limit <n>] [--json] | --rerun <n> [--var name=value]\n")
+		fmt.Fprintf(os.Stderr, "       sqlyac gen go <filepath> [--package <name>] [--placeholder ?|$]\n")
+		fmt.Fprintf(os.Stderr, "       sqlyac completion bash|zsh|fish\n")
 		os.Exit(0)
 	}
 
-	if !strings.HasSuffix(filepath, ".sql") {
-		fmt.Fprintf(os.Stderr, "error: file must have .sql extension\n")
+	if err := checkExtension(filepath, config.Extensions); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
 	}
 
-	queries, variables, err := parseSQL(filepath)
+	// the query file is coming in on stdin so answers have to come from the terminal
+	if filepath == "-" {
+		if tty, err := os.Open("/dev/tty"); err == nil {
+			defer tty.Close()
+			promptInput = tty
+		}
+	}
+
+	queries, variables, err := parseSQLWithOptions(filepath, config.parseOptions())
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error parsing sql: %v\n", err)
 		os.Exit(1)
 	}
+	for name, value := range vars {
+		variables[name] = value
+	}
 
-	if queryName == "" {
+	if len(names) == 0 && !all && tag == "" && len(queries) > 0 && isTerminal(os.Stdin) && isTerminal(os.Stderr) {
+		// let humans search for the query they want
+		picked, ok, err := pickQuery(queries, variables)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error opening query picker: %v\n", err)
+		} else if !ok {
+			fmt.Fprintf(os.Stderr, "cancelled\n")
+			os.Exit(1)
+		} else {
+			names = []string{picked.Name}
+		}
+	}
+
+	if len(names) == 0 && !all && tag == "" {
 		// list all available queries
 		fmt.Fprintf(os.Stderr, "available queries:\n")
 		for _, q := range queries {
@@ -76,126 +203,171 @@ func main() {
 		return
 	}
 
-	// find and output the requested query
-	for _, q := range queries {
-		if q.Name == queryName {
-			// interpolate variables into the query
-			interpolatedSQL, err := interpolateVariables(q.SQL, variables)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "error interpolating variables: %v\n", err)
-				os.Exit(1)
-			}
+	// find the requested queries
+	selected, err := selectQueries(queries, names, all, tag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
 
-			// check if we need confirmation based on config or flag
-			needsConfirm := confirm || config.Confirm ||
-				(config.ConfirmSchemaChanges && containsSchemaChanges(interpolatedSQL)) ||
-				(config.ConfirmUpdates && containsUpdates(interpolatedSQL))
+	if (explain && dryRun) || (explain && tx) || (dryRun && tx) {
+		fmt.Fprintf(os.Stderr, "error: use only one of --explain, --dry-run and --tx\n")
+		os.Exit(1)
+	}
+	if analyze && !explain {
+		fmt.Fprintf(os.Stderr, "error: --analyze only works with --explain\n")
+		os.Exit(1)
+	}
 
-			if needsConfirm && !confirmQuery(q.Name, interpolatedSQL) {
-				fmt.Fprintf(os.Stderr, "cancelled\n")
-				os.Exit(1)
-			}
-			fmt.Print(interpolatedSQL)
-			return
-		}
+	// explains and dry runs don't change anything, so running dependencies
+	// first wouldn't help them
+	plan, hasDeps, err := planQueries(queries, selected, !noDeps && !explain && !dryRun)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
 	}
 
-	fmt.Fprintf(os.Stderr, "error: query '%s' not found\n", queryName)
-	os.Exit(1)
-}
+	opts := runOptions{confirm: confirm, explain: explain, analyze: analyze, dryRun: dryRun, tx: tx, yesIMeanProd: yesIMeanProd}
+	configDialect := config.Dialect
+	if connName != "" {
+		conn, err := config.connection(connName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		if conn.Dialect != "" {
+			configDialect = conn.Dialect
+		}
+		if err := checkProtected(conn, promptIsTerminal(), yesIMeanProd); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		opts.conn = &conn
+	}
+	if explain || dryRun || tx || opts.conn != nil || hasCaptures(plan) {
+		opts.dialect, err = resolveDialect(dialectFlag, configDialect, filepath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
-func parseSQL(filepath string) ([]Query, map[string]string, error) {
-	file, err := os.Open(filepath)
-	if err != nil {
-		return nil, nil, err
+	if err := checkCaptures(plan, opts); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
 	}
-	defer file.Close()
-
-	var queries []Query
-	var currentQuery *Query
-	var sqlLines []string
-	variables := make(map[string]string)
-
-	scanner := bufio.NewScanner(file)
-	nameRegex := regexp.MustCompile(`--\s*@name\s*(\w+)`)
-	separatorRegex := regexp.MustCompile(`^---+$`)
-	// Updated regex to capture quoted vs unquoted values
-	variableRegex := regexp.MustCompile(`SET\s+@(\w+)\s*=\s*(.+?)(?:;|$)`)
-
-	for scanner.Scan() {
-		line := scanner.Text()
-
-		// check for variable definitions (SET @var="value" or SET @var=value)
-		if matches := variableRegex.FindStringSubmatch(strings.TrimSpace(line)); matches != nil {
-			varName := matches[1]
-			varValue := strings.TrimSpace(matches[2])
-			// Store the value as-is, preserving quotes or lack thereof
-			variables[varName] = varValue
-			continue
+
+	// refuse before asking about anything
+	if !explain || analyze {
+		if _, err := checkUnbounded(plan, variables, config); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
 		}
+	}
 
-		// check if this is a separator line
-		if separatorRegex.MatchString(strings.TrimSpace(line)) {
-			// if we have a current query, save it
-			if currentQuery != nil && currentQuery.Name != "" {
-				currentQuery.SQL = strings.TrimSpace(strings.Join(sqlLines, "\n"))
-				queries = append(queries, *currentQuery)
-			}
-			// reset for next query
-			currentQuery = &Query{}
-			sqlLines = []string{}
-			continue
+	if hasDeps && len(plan) > len(selected) {
+		// one question for everything instead of one per query
+		if !confirmPlan(plan, selected, opts) {
+			fmt.Fprintf(os.Stderr, "cancelled\n")
+			os.Exit(1)
 		}
+		opts.confirmed = true
+	}
 
-		// check for @name annotation
-		if matches := nameRegex.FindStringSubmatch(line); matches != nil {
-			if currentQuery != nil {
-				currentQuery.Name = matches[1]
+	opts.history = newHistoryLog(config, filepath, connName)
+	if len(names) > 0 {
+		// a rerun uses the real names, not the prefix or fuzzy match that found them
+		names = nil
+		for _, q := range selected {
+			names = append(names, q.Name)
+		}
+	}
+	opts.history.invoked(invocationArgs(names, all, tag, noDeps, connName, dialectFlag, vars, opts))
+	results, err := runQueries(plan, variables, config, opts, os.Stdout)
+	if len(results) > 1 {
+		printSummary(os.Stderr, results)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	for _, r := range results {
+		switch {
+		case r.status == "skipped" && len(results) == 1:
+			fmt.Fprintf(os.Stderr, "cancelled\n")
+			os.Exit(1)
+		case r.status == "rolled back":
+			if len(results) == 1 {
+				fmt.Fprintf(os.Stderr, "rolled back\n")
 			}
-			continue
+			os.Exit(1)
 		}
+	}
+}
 
-		// skip other comment lines that aren't @name
-		if strings.HasPrefix(strings.TrimSpace(line), "--") {
-			continue
+// parseArgs parses a flag set that allows flags before, between and after
+// positional args (the flag package stops at the first positional one) and
+// returns the positional args
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		// flag sets are created with ExitOnError so errors never come back here
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
 		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
 
-		// accumulate sql lines
-		if currentQuery != nil {
-			sqlLines = append(sqlLines, line)
+// checkExtension makes sure we're reading a query file. stdin (`-`) and
+// things that aren't regular files, like the pipes you get from process
+// substitution, are always allowed. a "*" extension allows anything.
+func checkExtension(path string, extensions []string) error {
+	if path == "-" {
+		return nil
+	}
+	if info, err := os.Stat(path); err == nil && !info.Mode().IsRegular() {
+		return nil
+	}
+	if len(extensions) == 0 {
+		extensions = defaultExtensions
+	}
+	for _, ext := range extensions {
+		if ext == "*" || strings.HasSuffix(path, ext) {
+			return nil
 		}
 	}
+	return fmt.Errorf("file must have one of these extensions: %s", strings.Join(extensions, ", "))
+}
 
-	// don't forget the last query if file doesn't end with separator
-	if currentQuery != nil && currentQuery.Name != "" {
-		currentQuery.SQL = strings.TrimSpace(strings.Join(sqlLines, "\n"))
-		queries = append(queries, *currentQuery)
-	}
+// parseSQL parses the query file at filepath with the default options
+func parseSQL(filepath string) ([]queryfile.Query, map[string]string, error) {
+	return parseSQLWithOptions(filepath, queryfile.Options{})
+}
 
-	return queries, variables, scanner.Err()
+// parseSQLWithOptions parses the query file at filepath, `-` reads from stdin
+func parseSQLWithOptions(filepath string, opts queryfile.Options) ([]queryfile.Query, map[string]string, error) {
+	var file *queryfile.File
+	var err error
+	if filepath == "-" {
+		file, err = queryfile.Parse(os.Stdin, "stdin", opts)
+	} else {
+		file, err = queryfile.ParseFile(filepath, opts)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return file.Queries, file.Variables, nil
 }
 
 func interpolateVariables(sql string, variables map[string]string) (string, error) {
-	// Match @variable_name patterns
-	variableRefRegex := regexp.MustCompile(`@(\w+)`)
-	
-	result := variableRefRegex.ReplaceAllStringFunc(sql, func(match string) string {
-		// Extract variable name from @variable_name
-		varName := variableRefRegex.FindStringSubmatch(match)[1]
-		
-		if value, exists := variables[varName]; exists {
-			// Return the value as-is (preserving original quoting)
-			return value
-		}
-		
-		// If variable not found, return the original match
-		return match
-	})
-	
-	return result, nil
+	return queryfile.Interpolate(sql, variables)
 }
 
-func confirmQuery(queryName, sql string) bool {
+func confirmQuery(queryName, sql string, opts runOptions) bool {
 	lines := strings.Split(sql, "\n")
 	preview := strings.Join(lines[:min(5, len(lines))], "\n")
 	if len(lines) > 5 {
@@ -204,15 +376,74 @@ func confirmQuery(queryName, sql string) bool {
 
 	fmt.Fprintf(os.Stderr, "\nquery: %s\n", queryName)
 	fmt.Fprintf(os.Stderr, "%s\n", preview)
-	fmt.Fprintf(os.Stderr, "\nrun this query? (y/n): ")
+
+	// show what each statement does when there's more than one
+	if statements := queryfile.SplitStatements(sql); len(statements) > 1 {
+		fmt.Fprintf(os.Stderr, "\n%d statements:\n", len(statements))
+		for i, statement := range statements {
+			fmt.Fprintf(os.Stderr, "  %d. %-5s %s\n", i+1, queryfile.Kind(statement), statementSummary(statement))
+		}
+	}
+
+	// statements that change whole tables get a louder warning
+	if unbounded := queryfile.UnboundedStatements(sql); len(unbounded) > 0 {
+		fmt.Fprintf(os.Stderr, "\nWARNING: this changes whole tables, not just some of their rows:\n")
+		for _, u := range unbounded {
+			fmt.Fprintf(os.Stderr, "  %-20s  %s\n", u.Reason, statementSummary(u.Statement))
+		}
+	}
+	return opts.ask("run this query?", queryName)
+}
+
+// promptIsTerminal reports whether confirmation answers come from a person
+func promptIsTerminal() bool {
+	f, ok := promptInput.(*os.File)
+	return ok && isTerminal(f)
+}
+
+// askYesNo asks a y/n question on stderr and reads the answer from promptInput
+func askYesNo(question string) bool {
+	fmt.Fprintf(os.Stderr, "\n%s (y/n): ", question)
 
 	var response string
-	fmt.Scanln(&response)
+	fmt.Fscanln(promptInput, &response)
 
 	response = strings.ToLower(strings.TrimSpace(response))
 	return response == "y" || response == "yes"
 }
 
+// askTyped asks a question on stderr that is only answered by typing one of
+// names, it's for when y is too easy
+func askTyped(question string, names ...string) bool {
+	fmt.Fprintf(os.Stderr, "\n%s type %s to go ahead: ", question, strings.Join(names, " or "))
+
+	var response string
+	fmt.Fscanln(promptInput, &response)
+
+	response = strings.TrimSpace(response)
+	for _, name := range names {
+		if response == name {
+			return true
+		}
+	}
+	return false
+}
+
+// statementSummary is the first line of a statement, shortened for prompts
+func statementSummary(statement string) string {
+	summary := statement
+	for _, line := range strings.Split(statement, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "--") {
+			summary = line
+			break
+		}
+	}
+	if len(summary) > 60 {
+		summary = summary[:57] + "..."
+	}
+	return summary
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
@@ -220,6 +451,21 @@ func min(a, b int) int {
 	return b
 }
 
+// loadConfigOrDefaults loads the config, falling back to the defaults when
+// there isn't one
+func loadConfigOrDefaults() *Config {
+	config, err := loadConfig()
+	if err != nil {
+		// if config doesn't exist, use defaults
+		config = &Config{
+			Confirm:              false,
+			ConfirmSchemaChanges: true,
+			ConfirmUpdates:       true,
+		}
+	}
+	return config
+}
+
 func loadConfig() (*Config, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
@@ -233,36 +479,16 @@ func loadConfig() (*Config, error) {
 		return nil, err
 	}
 
-	var config Config
+	// settings the file leaves out keep their defaults
+	config := Config{ConfirmSchemaChanges: true, ConfirmUpdates: true}
 	err = json.Unmarshal(data, &config)
 	return &config, err
 }
 
 func containsSchemaChanges(sql string) bool {
-	sql = strings.ToLower(sql)
-	schemaKeywords := []string{
-		"drop table", "drop database", "drop schema",
-		"alter table", "alter database", "alter schema",
-		"create table", "create database", "create schema",
-		"truncate table", "truncate",
-	}
-
-	for _, keyword := range schemaKeywords {
-		if strings.Contains(sql, keyword) {
-			return true
-		}
-	}
-	return false
+	return queryfile.ContainsSchemaChanges(sql)
 }
 
 func containsUpdates(sql string) bool {
-	sql = strings.ToLower(sql)
-	updateKeywords := []string{"update ", "delete ", "delete from", "insert"}
-
-	for _, keyword := range updateKeywords {
-		if strings.Contains(sql, keyword) {
-			return true
-		}
-	}
-	return false
+	return queryfile.ContainsUpdates(sql)
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -283,7 +284,6 @@ func TestExampleSQLStructure(t *testing.T) {
 	}
 }
 
-
 func TestLoadConfig(t *testing.T) {
 	// create a temp config file
 	tempDir, err := os.MkdirTemp("", "sqlyac_test")
@@ -355,6 +355,39 @@ func TestLoadConfigMissingFile(t *testing.T) {
 	}
 }
 
+func TestLoadConfigKeepsDefaults(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "sqlyac_test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	configDir := filepath.Join(tempDir, ".sqlyac")
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatalf("failed to create config dir: %v", err)
+	}
+	// a config with only connections still asks before changes
+	data := `{"connections": {"local": {"dialect": "sqlite", "command": ["sqlite3", "dev.db"]}}}`
+	if err := os.WriteFile(filepath.Join(configDir, "config.json"), []byte(data), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	originalHome := os.Getenv("HOME")
+	os.Setenv("HOME", tempDir)
+	defer os.Setenv("HOME", originalHome)
+
+	config, err := loadConfig()
+	if err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	if !config.ConfirmSchemaChanges || !config.ConfirmUpdates {
+		t.Errorf("expected schema changes and updates to ask, got %+v", config)
+	}
+	if _, ok := config.Connections["local"]; !ok {
+		t.Error("expected the local connection to load")
+	}
+}
+
 func TestContainsSchemaChanges(t *testing.T) {
 	testCases := []struct {
 		sql      string
@@ -598,3 +631,97 @@ func TestInterpolateVariablesWithMissingVar(t *testing.T) {
 		}
 }
 
+func TestCheckExtension(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "sqlyac_test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	for _, name := range []string{"queries.sql", "queries.pgsql", "queries.txt"} {
+		if err := os.WriteFile(filepath.Join(tempDir, name), nil, 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	testCases := []struct {
+		path       string
+		extensions []string
+		ok         bool
+		desc       string
+	}{
+		{"queries.sql", nil, true, "default .sql"},
+		{"queries.pgsql", nil, true, "default .pgsql"},
+		{"queries.txt", nil, false, "not a query file"},
+		{"queries.txt", []string{".txt"}, true, "configured extension"},
+		{"queries.sql", []string{".txt"}, false, "configured extensions replace the defaults"},
+		{"queries.txt", []string{"*"}, true, "anything goes"},
+	}
+
+	for _, tc := range testCases {
+		err := checkExtension(filepath.Join(tempDir, tc.path), tc.extensions)
+		if (err == nil) != tc.ok {
+			t.Errorf("checkExtension(%s, %v) returned %v (%s)", tc.path, tc.extensions, err, tc.desc)
+		}
+	}
+
+	if err := checkExtension("-", nil); err != nil {
+		t.Errorf("stdin should always be allowed, got %v", err)
+	}
+
+	// process substitution gives you a pipe like /dev/fd/63
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed to create pipe: %v", err)
+	}
+	defer r.Close()
+	defer w.Close()
+	if err := checkExtension(fmt.Sprintf("/dev/fd/%d", r.Fd()), nil); err != nil {
+		t.Errorf("pipes should always be allowed, got %v", err)
+	}
+}
+
+func TestConfirmQueryReadsPromptInput(t *testing.T) {
+	originalInput := promptInput
+	defer func() { promptInput = originalInput }()
+
+	promptInput = strings.NewReader("yes\n")
+	if !confirmQuery("DropUsers", "DROP TABLE users;", runOptions{}) {
+		t.Error("expected yes to confirm")
+	}
+
+	promptInput = strings.NewReader("n\n")
+	if confirmQuery("DropUsers", "DROP TABLE users;", runOptions{}) {
+		t.Error("expected n to cancel")
+	}
+}
+
+func TestConfirmQueryOnProtectedConnection(t *testing.T) {
+	originalInput := promptInput
+	defer func() { promptInput = originalInput }()
+
+	opts := runOptions{conn: &Connection{Name: "prod", Protected: true}}
+	for answer, expected := range map[string]bool{"y": false, "yes": false, "Prod": false, "prod": true, "DropUsers": true} {
+		promptInput = strings.NewReader(answer + "\n")
+		if got := confirmQuery("DropUsers", "DROP TABLE users;", opts); got != expected {
+			t.Errorf("answering %q: expected %v, got %v", answer, expected, got)
+		}
+	}
+
+	opts.yesIMeanProd = true
+	promptInput = strings.NewReader("")
+	if !confirmQuery("DropUsers", "DROP TABLE users;", opts) {
+		t.Error("expected --yes-i-mean-prod to confirm")
+	}
+	// it only stands in for the typed name, not for other questions
+	if (runOptions{yesIMeanProd: true}).saysYes() {
+		t.Error("expected --yes-i-mean-prod to only answer on a protected connection")
+	}
+}
+
+func TestStatementSummary(t *testing.T) {
+	summary := statementSummary("-- remove old rows\nDELETE FROM orders\nWHERE created_at < '2020-01-01'")
+	if summary != "DELETE FROM orders" {
+		t.Errorf("expected the first line of sql, got %q", summary)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kalli/sqlyac/queryfile"
+)
+
+// findQuery looks up a query by name. An exact match always wins, after that
+// we try a case-insensitive match, then a prefix and finally a fuzzy
+// (in-order characters) match. Anything that matches more than one query is
+// refused so we never run the wrong thing by accident. guessed is set for
+// prefix and fuzzy matches, those have to be confirmed before they run.
+func findQuery(queries []queryfile.Query, name string) (q queryfile.Query, guessed bool, err error) {
+	for _, q := range queries {
+		if q.Name == name {
+			return q, false, nil
+		}
+	}
+
+	lower := strings.ToLower(name)
+	matchers := []func(string) bool{
+		func(candidate string) bool { return candidate == lower },
+		func(candidate string) bool { return strings.HasPrefix(candidate, lower) },
+		func(candidate string) bool { return isSubsequence(lower, candidate) },
+	}
+
+	for i, matches := range matchers {
+		var found []queryfile.Query
+		for _, q := range queries {
+			if matches(strings.ToLower(q.Name)) {
+				found = append(found, q)
+			}
+		}
+		if len(found) == 1 {
+			return found[0], i > 0, nil
+		}
+		if len(found) > 1 {
+			var names []string
+			for _, q := range found {
+				names = append(names, q.Name)
+			}
+			return queryfile.Query{}, false, fmt.Errorf("query '%s' is ambiguous, it matches:\n  %s", name, strings.Join(names, "\n  "))
+		}
+	}
+
+	if suggestions := suggestQueries(queries, name); len(suggestions) > 0 {
+		return queryfile.Query{}, false, fmt.Errorf("query '%s' not found, did you mean:\n  %s", name, strings.Join(suggestions, "\n  "))
+	}
+	return queryfile.Query{}, false, fmt.Errorf("query '%s' not found", name)
+}
+
+// confirmGuess asks whether a prefix or fuzzy match is the query that was
+// meant, without a terminal to ask on it's refused
+func confirmGuess(name string, q queryfile.Query) error {
+	if promptIsTerminal() && askYesNo(fmt.Sprintf("there's no query '%s', use %s?", name, q.Name)) {
+		return nil
+	}
+	return fmt.Errorf("query '%s' not found, did you mean:\n  %s", name, q.Name)
+}
+
+// suggestQueries returns up to three query names that are a close edit
+// distance away from name, closest first
+func suggestQueries(queries []queryfile.Query, name string) []string {
+	type suggestion struct {
+		name     string
+		distance int
+	}
+
+	lower := strings.ToLower(name)
+	// allow roughly one typo per three characters
+	maxDistance := max(2, len(lower)/3)
+
+	var suggestions []suggestion
+	for _, q := range queries {
+		distance := editDistance(lower, strings.ToLower(q.Name))
+		if distance <= maxDistance {
+			suggestions = append(suggestions, suggestion{q.Name, distance})
+		}
+	}
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		return suggestions[i].distance < suggestions[j].distance
+	})
+
+	var names []string
+	for i := 0; i < len(suggestions) && i < 3; i++ {
+		names = append(names, suggestions[i].name)
+	}
+	return names
+}
+
+// isSubsequence reports whether all characters of needle appear in haystack in order
+func isSubsequence(needle, haystack string) bool {
+	runes := []rune(needle)
+	if len(runes) == 0 {
+		return false
+	}
+	i := 0
+	for _, r := range haystack {
+		if i < len(runes) && runes[i] == r {
+			i++
+		}
+	}
+	return i == len(runes)
+}
+
+// editDistance is the levenshtein distance between a and b
+func editDistance(a, b string) int {
+	ar, br := []rune(a), []rune(b)
+	prev := make([]int, len(br)+1)
+	curr := make([]int, len(br)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ar); i++ {
+		curr[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, min(curr[j-1]+1, prev[j-1]+cost))
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(br)]
+}
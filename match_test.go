@@ -0,0 +1,120 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kalli/sqlyac/queryfile"
+)
+
+var matchQueries = []queryfile.Query{
+	{Name: "GetAllUsers"},
+	{Name: "GetActiveUsers"},
+	{Name: "GetLargeOrders"},
+	{Name: "getallusers"},
+	{Name: "CleanupTestData"},
+}
+
+func TestFindQuery(t *testing.T) {
+	testCases := []struct {
+		name     string
+		expected string
+		desc     string
+	}{
+		{"GetAllUsers", "GetAllUsers", "exact match wins over case-insensitive duplicates"},
+		{"getallusers", "getallusers", "exact lowercase match"},
+		{"cleanuptestdata", "CleanupTestData", "case-insensitive match"},
+		{"getlarge", "GetLargeOrders", "unique prefix"},
+		{"cleanup", "CleanupTestData", "unique case-insensitive prefix"},
+		{"gactive", "GetActiveUsers", "fuzzy match"},
+	}
+
+	for i, tc := range testCases {
+		q, guessed, err := findQuery(matchQueries, tc.name)
+		if err != nil {
+			t.Errorf("findQuery(%q) failed: %v (%s)", tc.name, err, tc.desc)
+			continue
+		}
+		if q.Name != tc.expected {
+			t.Errorf("findQuery(%q) = %s, expected %s (%s)", tc.name, q.Name, tc.expected, tc.desc)
+		}
+		// only exact and case-insensitive matches are certain
+		if expectGuess := i > 2; guessed != expectGuess {
+			t.Errorf("findQuery(%q) guessed = %v, expected %v (%s)", tc.name, guessed, expectGuess, tc.desc)
+		}
+	}
+}
+
+func TestConfirmGuessWithoutTerminal(t *testing.T) {
+	originalInput := promptInput
+	defer func() { promptInput = originalInput }()
+	promptInput = strings.NewReader("y\n")
+
+	err := confirmGuess("gactive", queryfile.Query{Name: "GetActiveUsers"})
+	if err == nil || !strings.Contains(err.Error(), "did you mean:\n  GetActiveUsers") {
+		t.Errorf("expected the guess to be refused with the candidate, got %v", err)
+	}
+}
+
+func TestFindQueryAmbiguous(t *testing.T) {
+	_, _, err := findQuery(matchQueries, "GetA")
+	if err == nil {
+		t.Fatal("expected an error for an ambiguous name, got none")
+	}
+	if !strings.Contains(err.Error(), "ambiguous") {
+		t.Errorf("expected ambiguous error, got: %v", err)
+	}
+	for _, candidate := range []string{"GetAllUsers", "GetActiveUsers", "getallusers"} {
+		if !strings.Contains(err.Error(), candidate) {
+			t.Errorf("expected %s in the candidate list, got: %v", candidate, err)
+		}
+	}
+	if strings.Contains(err.Error(), "GetLargeOrders") {
+		t.Errorf("GetLargeOrders should not be a candidate: %v", err)
+	}
+}
+
+func TestFindQueryNotFound(t *testing.T) {
+	_, _, err := findQuery(matchQueries, "GetLrageOrders")
+	if err == nil {
+		t.Fatal("expected an error for a missing query, got none")
+	}
+	if !strings.Contains(err.Error(), "did you mean") || !strings.Contains(err.Error(), "GetLargeOrders") {
+		t.Errorf("expected a suggestion for GetLargeOrders, got: %v", err)
+	}
+
+	_, _, err = findQuery(matchQueries, "xyz")
+	if err == nil {
+		t.Fatal("expected an error for a missing query, got none")
+	}
+	if strings.Contains(err.Error(), "did you mean") {
+		t.Errorf("expected no suggestions for a name nothing like the queries, got: %v", err)
+	}
+}
+
+func TestSuggestQueriesRanking(t *testing.T) {
+	queries := []queryfile.Query{{Name: "GetOrders"}, {Name: "GetUsers"}, {Name: "GetUser"}}
+	suggestions := suggestQueries(queries, "GetUsr")
+	if len(suggestions) < 2 || suggestions[0] != "GetUser" || suggestions[1] != "GetUsers" {
+		t.Errorf("expected closest suggestions first, got %v", suggestions)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	testCases := []struct {
+		a, b     string
+		expected int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"kitten", "sitting", 3},
+		{"getusers", "getusers", 0},
+		{"getusrs", "getusers", 1},
+	}
+
+	for _, tc := range testCases {
+		if d := editDistance(tc.a, tc.b); d != tc.expected {
+			t.Errorf("editDistance(%q, %q) = %d, expected %d", tc.a, tc.b, d, tc.expected)
+		}
+	}
+}
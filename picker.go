@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/kalli/sqlyac/queryfile"
+)
+
+// picker is the state of the interactive query picker
+type picker struct {
+	queries   []queryfile.Query
+	variables map[string]string
+	input     string
+	matches   []queryfile.Query
+	selected  int
+}
+
+func newPicker(queries []queryfile.Query, variables map[string]string) *picker {
+	p := &picker{queries: queries, variables: variables}
+	p.filter()
+	return p
+}
+
+// filter narrows the matches down to queries whose name fuzzy matches the
+// input or whose description contains it
+func (p *picker) filter() {
+	p.matches = nil
+	needle := strings.ToLower(p.input)
+	for _, q := range p.queries {
+		if needle == "" ||
+			isSubsequence(needle, strings.ToLower(q.Name)) ||
+			strings.Contains(strings.ToLower(q.Description), needle) {
+			p.matches = append(p.matches, q)
+		}
+	}
+	if p.selected >= len(p.matches) {
+		p.selected = max(0, len(p.matches)-1)
+	}
+}
+
+// handleKey applies a keypress (or escape sequence) read from the terminal,
+// it returns done once the user picked a query or cancelled
+func (p *picker) handleKey(key []byte) (done, cancelled bool) {
+	switch string(key) {
+	case "\r", "\n":
+		return true, len(p.matches) == 0
+	case "\x03", "\x1b": // ctrl-c, esc
+		return true, true
+	case "\x1b[A", "\x1bOA", "\x10": // up, ctrl-p
+		if p.selected > 0 {
+			p.selected--
+		}
+	case "\x1b[B", "\x1bOB", "\x0e": // down, ctrl-n
+		if p.selected < len(p.matches)-1 {
+			p.selected++
+		}
+	case "\x7f", "\x08": // backspace
+		if p.input != "" {
+			_, size := utf8.DecodeLastRuneInString(p.input)
+			p.input = p.input[:len(p.input)-size]
+			p.filter()
+		}
+	case "\x15": // ctrl-u
+		p.input = ""
+		p.filter()
+	default:
+		// ignore other escape sequences and control characters
+		if key[0] == 0x1b {
+			return false, false
+		}
+		for _, r := range string(key) {
+			if unicode.IsPrint(r) {
+				p.input += string(r)
+			}
+		}
+		p.selected = 0
+		p.filter()
+	}
+	return false, false
+}
+
+// render draws the search line, the matching queries and a preview of the
+// selected one. the terminal is in raw mode so lines end in \r\n
+func (p *picker) render(w io.Writer, rows, cols int) {
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+
+	line := func(s string) {
+		if runes := []rune(s); len(runes) > cols {
+			s = string(runes[:cols])
+		}
+		b.WriteString(s + "\r\n")
+	}
+
+	line(fmt.Sprintf("query> %s", p.input))
+
+	listHeight := min(len(p.matches), max(3, (rows-2)/2))
+	offset := 0
+	if p.selected >= listHeight {
+		offset = p.selected - listHeight + 1
+	}
+	for i := offset; i < offset+listHeight && i < len(p.matches); i++ {
+		q := p.matches[i]
+		marker := "  "
+		if i == p.selected {
+			marker = "> "
+		}
+		entry := marker + q.Name
+		if q.Description != "" {
+			entry += "  - " + q.Description
+		}
+		line(entry)
+	}
+	line(fmt.Sprintf("-- %d/%d --", len(p.matches), len(p.queries)))
+
+	if len(p.matches) > 0 {
+		sql, _ := interpolateVariables(p.matches[p.selected].SQL, p.variables)
+		previewLines := strings.Split(sql, "\n")
+		available := rows - listHeight - 3
+		for i := 0; i < len(previewLines) && i < available; i++ {
+			line(previewLines[i])
+		}
+	}
+
+	// park the cursor at the end of the search input
+	b.WriteString(fmt.Sprintf("\x1b[1;%dH", len("query> ")+utf8.RuneCountInString(p.input)+1))
+	io.WriteString(w, b.String())
+}
+
+// pickQuery lets the user search for a query on the terminal. it talks to
+// /dev/tty directly so it works while stdout is piped somewhere else
+func pickQuery(queries []queryfile.Query, variables map[string]string) (queryfile.Query, bool, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return queryfile.Query{}, false, err
+	}
+	defer tty.Close()
+
+	saved, err := stty(tty, "-g")
+	if err != nil {
+		return queryfile.Query{}, false, err
+	}
+	if _, err := stty(tty, "raw", "-echo"); err != nil {
+		return queryfile.Query{}, false, err
+	}
+	defer stty(tty, strings.TrimSpace(saved))
+
+	rows, cols := 24, 80
+	if size, err := stty(tty, "size"); err == nil {
+		var r, c int
+		// some terminals (and pseudo terminals) report 0 0
+		if fmt.Sscanf(size, "%d %d", &r, &c); r > 0 && c > 0 {
+			rows, cols = r, c
+		}
+	}
+
+	// use the alternate screen so the picker doesn't leave a mess behind
+	io.WriteString(tty, "\x1b[?1049h")
+	defer io.WriteString(tty, "\x1b[?1049l")
+
+	p := newPicker(queries, variables)
+	buf := make([]byte, 32)
+	for {
+		p.render(tty, rows, cols)
+		n, err := tty.Read(buf)
+		if err != nil {
+			return queryfile.Query{}, false, err
+		}
+		if n == 0 {
+			continue
+		}
+		for _, key := range splitKeys(buf[:n]) {
+			if done, cancelled := p.handleKey(key); done {
+				if cancelled {
+					return queryfile.Query{}, false, nil
+				}
+				return p.matches[p.selected], true, nil
+			}
+		}
+	}
+}
+
+// splitKeys splits what was read from the terminal into separate keypresses,
+// pasting or typing fast can deliver several in one read
+func splitKeys(buf []byte) [][]byte {
+	var keys [][]byte
+	for len(buf) > 0 {
+		size := 1
+		if buf[0] == 0x1b && len(buf) > 2 && (buf[1] == '[' || buf[1] == 'O') {
+			// escape sequences end with a byte in the @ to ~ range
+			size = 2
+			for size < len(buf) {
+				size++
+				if buf[size-1] >= 0x40 && buf[size-1] <= 0x7e {
+					break
+				}
+			}
+		} else if buf[0] >= utf8.RuneSelf {
+			_, size = utf8.DecodeRune(buf)
+		}
+		keys = append(keys, buf[:size])
+		buf = buf[size:]
+	}
+	return keys
+}
+
+// stty runs stty against the given terminal and returns its output
+func stty(tty *os.File, args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = tty
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// isTerminal reports whether f is an interactive terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	// /dev/null is a character device too, only terminals have settings
+	_, err = stty(f, "-g")
+	return err == nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kalli/sqlyac/queryfile"
+)
+
+var pickerQueries = []queryfile.Query{
+	{Name: "GetAllUsers", SQL: "SELECT * FROM users;"},
+	{Name: "GetLargeOrders", SQL: "SELECT * FROM orders WHERE total > @min_total;", Description: "Orders over the minimum"},
+	{Name: "CleanupTestData", SQL: "DROP TABLE orders;", Description: "Drop everything"},
+}
+
+func pickerNames(p *picker) []string {
+	var names []string
+	for _, q := range p.matches {
+		names = append(names, q.Name)
+	}
+	return names
+}
+
+func TestPickerFilter(t *testing.T) {
+	p := newPicker(pickerQueries, nil)
+	if len(p.matches) != 3 {
+		t.Errorf("expected all queries to match an empty search, got %v", pickerNames(p))
+	}
+
+	for _, key := range splitKeys([]byte("gusers")) {
+		p.handleKey(key)
+	}
+	if names := pickerNames(p); !reflect.DeepEqual(names, []string{"GetAllUsers"}) {
+		t.Errorf("expected fuzzy name match, got %v", names)
+	}
+
+	p.handleKey([]byte("\x15"))
+	for _, key := range splitKeys([]byte("everything")) {
+		p.handleKey(key)
+	}
+	if names := pickerNames(p); !reflect.DeepEqual(names, []string{"CleanupTestData"}) {
+		t.Errorf("expected description match, got %v", names)
+	}
+}
+
+func TestPickerKeys(t *testing.T) {
+	p := newPicker(pickerQueries, nil)
+
+	p.handleKey([]byte("\x1b[B"))
+	p.handleKey([]byte("\x1b[B"))
+	p.handleKey([]byte("\x1b[B"))
+	if p.selected != 2 {
+		t.Errorf("expected selection to stop at the last match, got %d", p.selected)
+	}
+	p.handleKey([]byte("\x10"))
+	if p.selected != 1 {
+		t.Errorf("expected ctrl-p to move up, got %d", p.selected)
+	}
+
+	if done, cancelled := p.handleKey([]byte("\r")); !done || cancelled {
+		t.Errorf("expected enter to pick, got done=%v cancelled=%v", done, cancelled)
+	}
+	if done, cancelled := p.handleKey([]byte("\x1b")); !done || !cancelled {
+		t.Errorf("expected esc to cancel, got done=%v cancelled=%v", done, cancelled)
+	}
+
+	p.handleKey([]byte("x"))
+	p.handleKey([]byte("z"))
+	if done, cancelled := p.handleKey([]byte("\r")); !done || !cancelled {
+		t.Errorf("expected enter without matches to cancel, got done=%v cancelled=%v", done, cancelled)
+	}
+	p.handleKey([]byte("\x7f"))
+	if p.input != "x" {
+		t.Errorf("expected backspace to remove the last character, got %q", p.input)
+	}
+}
+
+func TestSplitKeys(t *testing.T) {
+	keys := splitKeys([]byte("a\x1b[Bé\x7f\x1b"))
+	expected := [][]byte{[]byte("a"), []byte("\x1b[B"), []byte("é"), []byte("\x7f"), []byte("\x1b")}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected %q, got %q", expected, keys)
+	}
+}
+
+func TestPickerRender(t *testing.T) {
+	p := newPicker(pickerQueries, map[string]string{"min_total": "100"})
+	p.handleKey([]byte("\x1b[B"))
+
+	var out strings.Builder
+	p.render(&out, 24, 80)
+	rendered := out.String()
+
+	if !strings.Contains(rendered, "> GetLargeOrders  - Orders over the minimum") {
+		t.Errorf("expected the selected query to be marked, got %q", rendered)
+	}
+	if !strings.Contains(rendered, "WHERE total > 100;") {
+		t.Errorf("expected an interpolated preview, got %q", rendered)
+	}
+}
@@ -0,0 +1,67 @@
+package queryfile
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Plan returns the named queries together with everything they depend on,
+// in an order where every query comes after its dependencies. otherwise the
+// queries keep the order they're in.
+func Plan(queries []Query, names ...string) ([]Query, error) {
+	byName := make(map[string]Query)
+	for _, q := range queries {
+		byName[q.Name] = q
+	}
+
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[string]int)
+	var plan []Query
+	var path []string
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case done:
+			return nil
+		case visiting:
+			// the cycle is the part of the path from the first time we saw name
+			for i, n := range path {
+				if n == name {
+					cycle := append(append([]string{}, path[i:]...), name)
+					return fmt.Errorf("dependency cycle: %s", strings.Join(cycle, " -> "))
+				}
+			}
+		}
+
+		q, ok := byName[name]
+		if !ok {
+			if len(path) > 0 {
+				return fmt.Errorf("query '%s' depends on '%s', which doesn't exist", path[len(path)-1], name)
+			}
+			return fmt.Errorf("query '%s' not found", name)
+		}
+
+		state[name] = visiting
+		path = append(path, name)
+		for _, dep := range q.Depends {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = done
+		plan = append(plan, q)
+		return nil
+	}
+
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+	return plan, nil
+}
@@ -0,0 +1,68 @@
+package queryfile
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPlan(t *testing.T) {
+	queries := []Query{
+		{Name: "CreateUsersTable"},
+		{Name: "CreateOrdersTable", Depends: []string{"CreateUsersTable"}},
+		{Name: "InsertSampleUsers", Depends: []string{"CreateUsersTable"}},
+		{Name: "InsertSampleOrders", Depends: []string{"CreateOrdersTable", "InsertSampleUsers"}},
+		{Name: "GetAllUsers"},
+	}
+
+	testCases := []struct {
+		names    []string
+		expected []string
+	}{
+		{[]string{"GetAllUsers"}, []string{"GetAllUsers"}},
+		{[]string{"InsertSampleOrders"}, []string{"CreateUsersTable", "CreateOrdersTable", "InsertSampleUsers", "InsertSampleOrders"}},
+		{[]string{"InsertSampleUsers", "CreateOrdersTable"}, []string{"CreateUsersTable", "InsertSampleUsers", "CreateOrdersTable"}},
+		{[]string{"InsertSampleOrders", "CreateUsersTable"}, []string{"CreateUsersTable", "CreateOrdersTable", "InsertSampleUsers", "InsertSampleOrders"}},
+	}
+
+	for _, tc := range testCases {
+		plan, err := Plan(queries, tc.names...)
+		if err != nil {
+			t.Fatalf("Plan(%v) failed: %v", tc.names, err)
+		}
+		var got []string
+		for _, q := range plan {
+			got = append(got, q.Name)
+		}
+		if !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("Plan(%v) = %v, expected %v", tc.names, got, tc.expected)
+		}
+	}
+}
+
+func TestPlanErrors(t *testing.T) {
+	queries := []Query{
+		{Name: "A", Depends: []string{"B"}},
+		{Name: "B", Depends: []string{"C"}},
+		{Name: "C", Depends: []string{"A"}},
+		{Name: "D", Depends: []string{"Missing"}},
+		{Name: "E", Depends: []string{"E"}},
+	}
+
+	testCases := []struct {
+		name     string
+		expected string
+	}{
+		{"A", "dependency cycle: A -> B -> C -> A"},
+		{"E", "dependency cycle: E -> E"},
+		{"D", "query 'D' depends on 'Missing', which doesn't exist"},
+		{"Nope", "query 'Nope' not found"},
+	}
+
+	for _, tc := range testCases {
+		_, err := Plan(queries, tc.name)
+		if err == nil || !strings.Contains(err.Error(), tc.expected) {
+			t.Errorf("Plan(%s): expected error %q, got %v", tc.name, tc.expected, err)
+		}
+	}
+}
@@ -0,0 +1,449 @@
+// Package queryfile parses sqlyac query files, plain sql files where each
+// query is named with a `-- @name` comment and queries are separated by `---`
+// lines. Variables set with `SET @name=value` can be interpolated into the
+// queries.
+//
+//	file, err := queryfile.ParseFile("queries.sql", queryfile.Options{})
+//	if err != nil {
+//		return err
+//	}
+//	q, ok := file.Query("GetUser")
+//	if !ok {
+//		return fmt.Errorf("no GetUser query")
+//	}
+//	sql, err := queryfile.Interpolate(q.SQL, file.Variables)
+package queryfile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var (
+	nameRegex       = regexp.MustCompile(`--\s*@name\s*(\w+)`)
+	annotationRegex = regexp.MustCompile(`^--\s*@([a-z][\w-]*)\s*(.*)$`)
+	separatorRegex  = regexp.MustCompile(`^---+$`)
+	// httpyac and jetbrains style separators
+	hashSeparatorRegex = regexp.MustCompile(`^(--\s*)?###`)
+	// `-- name: GetUser :one` (yesql, sqlc) and `-- :name get-user :? :1` (hugsql)
+	yesqlNameRegex = regexp.MustCompile(`^--\s*(?:name:|:name)\s*([\w-]+)(<?!)?(.*)$`)
+	// captures quoted vs unquoted values
+	variableRegex = regexp.MustCompile(`SET\s+@(\w+)\s*=\s*(.+?)(?:;|$)`)
+	// `@key value` on a line of a /* ... */ comment
+	blockAnnotationRegex = regexp.MustCompile(`^@([a-z][\w-]*)\s*(.*)$`)
+	// the query name at the start of a block comment @name value
+	blockNameRegex = regexp.MustCompile(`^\w*`)
+)
+
+// Query is a named query from a query file
+type Query struct {
+	Name        string
+	SQL         string
+	Statements  []string
+	Description string
+	Tags        []string
+	Params      []Param
+	// Depends are the queries that have to run before this one, from
+	// `-- @depends`
+	Depends []string
+	// Captures are the variables set from the query's result, from
+	// `-- @capture variable = column`
+	Captures []Capture
+	// Asserts are checks on the query's result from `-- @assert`, like
+	// `rowcount > 0`, for `sqlyac test`
+	Asserts []string
+	// ExpectError is set by `-- @expect-error`, the query is supposed to fail
+	ExpectError bool
+	// Result is the result cardinality hint, like sqlc's one, many or exec
+	Result string
+	File   string
+	Line   int
+	// KeepComments is set by `-- @keep-comments`
+	KeepComments bool
+	// AllowUnbounded is set by `-- @allow-unbounded`, the query is meant to
+	// change whole tables
+	AllowUnbounded bool
+}
+
+// Capture stores a column of the first row a query returns in a variable
+type Capture struct {
+	Variable string `json:"variable"`
+	Column   string `json:"column"`
+}
+
+// Param is a query parameter declared with a `-- @param` annotation
+type Param struct {
+	Name        string `json:"name"`
+	Type        string `json:"type,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Options change how query files are parsed, the zero value parses sqlyac files
+type Options struct {
+	// KeepComments keeps comment lines in the sql of every query, not just
+	// the ones annotated with @keep-comments
+	KeepComments bool
+	// Syntax is how queries are separated and named: "sqlyac" (the default),
+	// "implicit", "httpyac" or "yesql"
+	Syntax string
+}
+
+// File is a parsed query file
+type File struct {
+	Queries []Query
+	// Variables are the values set with `SET @name=value`, kept as written
+	// so quoted strings stay quoted
+	Variables map[string]string
+}
+
+// Query returns the query with exactly the given name
+func (f *File) Query(name string) (Query, bool) {
+	for _, q := range f.Queries {
+		if q.Name == name {
+			return q, true
+		}
+	}
+	return Query{}, false
+}
+
+// ParseFile parses the query file at path
+func ParseFile(path string, opts Options) (*File, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return Parse(file, path, opts)
+}
+
+// ParseFS parses the query file called name in fsys, like an embed.FS
+func ParseFS(fsys fs.FS, name string, opts Options) (*File, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return Parse(file, name, opts)
+}
+
+// Parse parses queries and variables from r, name is only used to record
+// where each query came from
+func Parse(r io.Reader, name string, opts Options) (*File, error) {
+	// in the implicit and yesql syntaxes every name starts a new query
+	implicit := false
+	switch opts.Syntax {
+	case "", "sqlyac", "httpyac":
+	case "implicit", "yesql":
+		implicit = true
+	default:
+		return nil, fmt.Errorf("unknown syntax %q, expected sqlyac, implicit, httpyac or yesql", opts.Syntax)
+	}
+
+	var queries []Query
+	var currentQuery *Query
+	var sqlLines []string
+	// same as sqlLines but with comments, for queries that keep them
+	var commentedLines []string
+	variables := make(map[string]string)
+	// tracks function bodies, DELIMITER blocks etc in the current query
+	splitter := newSQLSplitter()
+	// whether the current query has any sql yet, block comments before it
+	// can hold annotations
+	sawSQL := false
+	var docLines []string
+	inDocComment, docHasAnnotations := false, false
+	docLine := 0
+
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+
+	// namesQuery reports whether a line names a query. a lone separator can
+	// be a comment in a function body, a name can't.
+	namesQuery := func(line string) bool {
+		trimmed := strings.TrimSpace(line)
+		return strings.HasPrefix(trimmed, "--") &&
+			(nameRegex.MatchString(trimmed) || (opts.Syntax == "yesql" && yesqlNameRegex.MatchString(trimmed)))
+	}
+
+	// startQuery saves the current query, if it has a name, and starts a new one
+	startQuery := func() {
+		if currentQuery != nil && currentQuery.Name != "" {
+			queries = append(queries, finishQuery(currentQuery, sqlLines, commentedLines, opts))
+		}
+		currentQuery = &Query{File: name}
+		sqlLines = []string{}
+		commentedLines = []string{}
+		sawSQL = false
+		splitter = newSQLSplitter()
+	}
+
+	// nameQuery names the current query, with implicit blocks a name after
+	// another name or some sql starts a new query. httpyac files don't start
+	// with a separator, so a name before the first one starts a query too.
+	nameQuery := func(name string) {
+		if implicit && (currentQuery == nil || currentQuery.Name != "" || sawSQL) {
+			startQuery()
+		} else if opts.Syntax == "httpyac" && currentQuery == nil {
+			startQuery()
+		}
+		if currentQuery != nil {
+			currentQuery.Name = name
+			currentQuery.Line = lineNum
+		}
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		lineNum++
+
+		// inside a function or procedure body everything is sql, even lines
+		// that look like comments, separators or variables
+		if currentQuery != nil && splitter.inBody() {
+			// a body that never ends would swallow the queries after it
+			if namesQuery(line) {
+				return nil, fmt.Errorf("line %d: query '%s' is still inside %s, close it before the next query", lineNum, currentQuery.Name, splitter.openBlock())
+			}
+			sqlLines = append(sqlLines, line)
+			commentedLines = append(commentedLines, line)
+			splitter.feedLine(line)
+			continue
+		}
+
+		// a block comment before the sql, this also keeps separators inside
+		// the comment from splitting the query
+		if inDocComment || ((!sawSQL || implicit) && strings.HasPrefix(strings.TrimSpace(line), "/*")) {
+			comment, rest, closed := line, "", false
+			if end := strings.Index(line, "*/"); end >= 0 {
+				comment, rest, closed = line[:end+2], line[end+2:], true
+			}
+			// a one line comment followed by sql is just sql
+			if inDocComment || !closed || strings.TrimSpace(rest) == "" {
+				if !inDocComment {
+					docLine = lineNum
+				}
+				inDocComment = !closed
+				docLines = append(docLines, comment)
+				if key, value, ok := blockAnnotation(comment); ok {
+					docHasAnnotations = true
+					if key == "name" {
+						nameQuery(blockNameRegex.FindString(value))
+					} else if currentQuery != nil {
+						applyAnnotation(currentQuery, key, value)
+					}
+				}
+				if inDocComment {
+					continue
+				}
+
+				// comments without annotations are regular sql comments
+				if !docHasAnnotations && currentQuery != nil {
+					sqlLines = append(sqlLines, docLines...)
+					commentedLines = append(commentedLines, docLines...)
+				}
+				docLines, docHasAnnotations = nil, false
+				if strings.TrimSpace(rest) == "" {
+					continue
+				}
+				line = rest
+			}
+		}
+
+		// check for variable definitions (SET @var="value" or SET @var=value)
+		if matches := variableRegex.FindStringSubmatch(strings.TrimSpace(line)); matches != nil {
+			varName := matches[1]
+			varValue := strings.TrimSpace(matches[2])
+			// Store the value as-is, preserving quotes or lack thereof
+			variables[varName] = varValue
+			continue
+		}
+
+		// check if this is a separator line
+		if separatorRegex.MatchString(strings.TrimSpace(line)) ||
+			(opts.Syntax == "httpyac" && hashSeparatorRegex.MatchString(strings.TrimSpace(line))) {
+			// save the current query and reset for the next one
+			startQuery()
+			continue
+		}
+
+		// check for @name annotation
+		if matches := nameRegex.FindStringSubmatch(line); matches != nil {
+			nameQuery(matches[1])
+			continue
+		}
+		if opts.Syntax == "yesql" {
+			if matches := yesqlNameRegex.FindStringSubmatch(strings.TrimSpace(line)); matches != nil {
+				nameQuery(matches[1])
+				if currentQuery != nil {
+					currentQuery.Result = yesqlResult(matches[2], strings.Fields(matches[3]))
+				}
+				continue
+			}
+		}
+
+		// other annotations like @description, @tags and @param
+		if matches := annotationRegex.FindStringSubmatch(strings.TrimSpace(line)); matches != nil {
+			if currentQuery != nil {
+				applyAnnotation(currentQuery, matches[1], strings.TrimSpace(matches[2]))
+			}
+			continue
+		}
+
+		// skip other comment lines that aren't @name, unless the query keeps them
+		if strings.HasPrefix(strings.TrimSpace(line), "--") {
+			// yesql uses the comments between the name and the sql as the
+			// docstring, hugsql has `-- :doc`
+			if opts.Syntax == "yesql" && currentQuery != nil && currentQuery.Name != "" && !sawSQL {
+				doc := strings.TrimPrefix(strings.TrimSpace(line), "--")
+				doc = strings.TrimPrefix(strings.TrimSpace(doc), ":doc")
+				if doc = strings.TrimSpace(doc); doc != "" {
+					applyAnnotation(currentQuery, "description", doc)
+				}
+				continue
+			}
+			if currentQuery != nil {
+				commentedLines = append(commentedLines, line)
+			}
+			continue
+		}
+
+		// accumulate sql lines
+		if currentQuery != nil {
+			sqlLines = append(sqlLines, line)
+			commentedLines = append(commentedLines, line)
+			splitter.feedLine(line)
+			sawSQL = sawSQL || strings.TrimSpace(line) != ""
+		}
+	}
+
+	switch {
+	case inDocComment:
+		return nil, fmt.Errorf("line %d: the /* comment is never closed", docLine)
+	case currentQuery != nil && splitter.inBody():
+		return nil, fmt.Errorf("line %d: query '%s' is still inside %s at the end of the file", lineNum, currentQuery.Name, splitter.openBlock())
+	}
+
+	// don't forget the last query if file doesn't end with separator
+	startQuery()
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &File{Queries: queries, Variables: variables}, nil
+}
+
+// finishQuery sets the sql of a query from the lines collected for it
+func finishQuery(q *Query, sqlLines, commentedLines []string, opts Options) Query {
+	if opts.KeepComments || q.KeepComments {
+		sqlLines = commentedLines
+	}
+	q.SQL = strings.TrimSpace(strings.Join(sqlLines, "\n"))
+	q.Statements = SplitStatements(q.SQL)
+	return *q
+}
+
+// yesqlResult turns the hints after a yesql style name into a result
+// cardinality: sqlc's `:one`, hugsql's `:? :1` or yesql's `name!`
+func yesqlResult(suffix string, hints []string) string {
+	command := map[string]string{"!": "exec", "<!": "execlastid"}[suffix]
+	for _, hint := range hints {
+		switch hint {
+		case ":one", ":1":
+			return "one"
+		case ":many", ":*":
+			return "many"
+		case ":n", ":affected":
+			return "execrows"
+		case ":exec", ":execrows", ":execresult", ":execlastid", ":copyfrom", ":batchexec", ":batchmany", ":batchone":
+			return strings.TrimPrefix(hint, ":")
+		case ":!", ":execute":
+			command = "exec"
+		case ":i!", ":insert":
+			command = "execlastid"
+		case ":?", ":query", ":<!", ":returning-execute":
+			command = "many"
+		}
+	}
+	return command
+}
+
+// blockAnnotation finds an annotation on a line of a /* ... */ comment
+func blockAnnotation(line string) (key, value string, ok bool) {
+	line = strings.TrimSpace(line)
+	line = strings.TrimSuffix(strings.TrimPrefix(line, "/*"), "*/")
+	line = strings.TrimSpace(strings.TrimLeft(line, "*"))
+	matches := blockAnnotationRegex.FindStringSubmatch(line)
+	if matches == nil {
+		return "", "", false
+	}
+	return matches[1], strings.TrimSpace(matches[2]), true
+}
+
+// applyAnnotation stores the value of an annotation comment on the query,
+// unknown annotations are ignored like any other comment
+func applyAnnotation(q *Query, key, value string) {
+	switch key {
+	case "description":
+		if q.Description != "" {
+			q.Description += " "
+		}
+		q.Description += value
+	case "tag", "tags":
+		for _, tag := range strings.FieldsFunc(value, func(r rune) bool {
+			return r == ',' || unicode.IsSpace(r)
+		}) {
+			q.Tags = append(q.Tags, tag)
+		}
+	case "depends":
+		for _, name := range strings.FieldsFunc(value, func(r rune) bool {
+			return r == ',' || unicode.IsSpace(r)
+		}) {
+			q.Depends = append(q.Depends, name)
+		}
+	case "capture":
+		// -- @capture <variable> [= <column>], the column defaults to the
+		// variable's name
+		variable, column, found := strings.Cut(value, "=")
+		variable = strings.TrimPrefix(strings.TrimSpace(variable), "@")
+		column = strings.TrimSpace(column)
+		if !found || column == "" {
+			column = variable
+		}
+		if variable != "" {
+			q.Captures = append(q.Captures, Capture{Variable: variable, Column: column})
+		}
+	case "assert":
+		if value != "" {
+			q.Asserts = append(q.Asserts, value)
+		}
+	case "expect-error":
+		q.ExpectError = true
+	case "result":
+		q.Result = value
+	case "keep-comments":
+		q.KeepComments = true
+	case "allow-unbounded":
+		q.AllowUnbounded = true
+	case "param":
+		// -- @param <name> [type] [description]
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			return
+		}
+		param := Param{Name: strings.TrimPrefix(fields[0], "@")}
+		if len(fields) > 1 {
+			param.Type = fields[1]
+		}
+		if len(fields) > 2 {
+			param.Description = strings.Join(fields[2:], " ")
+		}
+		q.Params = append(q.Params, param)
+	}
+}
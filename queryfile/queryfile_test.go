@@ -0,0 +1,692 @@
+package queryfile
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestParseAnnotations(t *testing.T) {
+	testSQL := `---
+-- @name GetUser
+-- @description Fetch a single user
+-- @description by their id
+-- @tags users, lookup
+-- @tag reports
+-- @param user_id int the id of the user
+-- @param @status
+-- @depends CreateUsersTable, CreateOrdersTable
+-- @capture @user_name = username
+-- @capture email
+-- @assert rowcount = 1
+-- @assert col(status) in ('active', 'pending')
+-- @allow-unbounded
+-- @unknown annotations are just comments
+SELECT * FROM users WHERE id=@user_id AND status=@status;
+---`
+
+	tmpFile, err := os.CreateTemp("", "annotations*.sql")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	tmpFile.WriteString(testSQL)
+	tmpFile.Close()
+
+	file, err := ParseFile(tmpFile.Name(), Options{})
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
+	}
+	queries := file.Queries
+	if len(queries) != 1 {
+		t.Fatalf("expected 1 query, got %d", len(queries))
+	}
+
+	query := queries[0]
+	if query.Description != "Fetch a single user by their id" {
+		t.Errorf("unexpected description: %q", query.Description)
+	}
+	if !reflect.DeepEqual(query.Tags, []string{"users", "lookup", "reports"}) {
+		t.Errorf("unexpected tags: %v", query.Tags)
+	}
+	if !reflect.DeepEqual(query.Depends, []string{"CreateUsersTable", "CreateOrdersTable"}) {
+		t.Errorf("unexpected depends: %v", query.Depends)
+	}
+	expectedCaptures := []Capture{{Variable: "user_name", Column: "username"}, {Variable: "email", Column: "email"}}
+	if !reflect.DeepEqual(query.Captures, expectedCaptures) {
+		t.Errorf("expected captures %v, got %v", expectedCaptures, query.Captures)
+	}
+	if !reflect.DeepEqual(query.Asserts, []string{"rowcount = 1", "col(status) in ('active', 'pending')"}) || query.ExpectError {
+		t.Errorf("unexpected asserts: %v %v", query.Asserts, query.ExpectError)
+	}
+	if !query.AllowUnbounded {
+		t.Error("expected @allow-unbounded to be set")
+	}
+	expectedParams := []Param{
+		{Name: "user_id", Type: "int", Description: "the id of the user"},
+		{Name: "status"},
+	}
+	if !reflect.DeepEqual(query.Params, expectedParams) {
+		t.Errorf("expected params %v, got %v", expectedParams, query.Params)
+	}
+	if query.File != tmpFile.Name() || query.Line != 2 {
+		t.Errorf("expected location %s:2, got %s:%d", tmpFile.Name(), query.File, query.Line)
+	}
+	// annotations should not end up in the sql
+	if query.SQL != "SELECT * FROM users WHERE id=@user_id AND status=@status;" {
+		t.Errorf("unexpected sql: %q", query.SQL)
+	}
+}
+
+func TestKind(t *testing.T) {
+	testCases := []struct {
+		sql      string
+		expected string
+	}{
+		{"SELECT * FROM users", "read"},
+		{"UPDATE users SET name = 'test'", "write"},
+		{"INSERT INTO users VALUES (1, 'test')", "write"},
+		{"DROP TABLE users", "ddl"},
+		{"CREATE TABLE test (id INT)", "ddl"},
+	}
+
+	for _, tc := range testCases {
+		if kind := Kind(tc.sql); kind != tc.expected {
+			t.Errorf("Kind(%q) = %s, expected %s", tc.sql, kind, tc.expected)
+		}
+	}
+}
+
+func TestUnboundedStatements(t *testing.T) {
+	testCases := []struct {
+		sql      string
+		expected []string
+	}{
+		{"SELECT * FROM users", nil},
+		{"DELETE FROM orders WHERE id = 1", nil},
+		{"DELETE FROM orders", []string{"DELETE without WHERE"}},
+		{"update users\nset active = 0;", []string{"UPDATE without WHERE"}},
+		{"UPDATE users SET active = (SELECT 0 FROM flags WHERE id = 1)", []string{"UPDATE without WHERE"}},
+		{"DELETE FROM orders -- WHERE id = 1", []string{"DELETE without WHERE"}},
+		{"UPDATE users SET note = 'where'", []string{"UPDATE without WHERE"}},
+		{"WITH old AS (SELECT id FROM orders WHERE created < '2020-01-01') DELETE FROM orders", []string{"DELETE without WHERE"}},
+		{"WITH old AS (SELECT id FROM orders) DELETE FROM orders WHERE id IN (SELECT id FROM old)", nil},
+		{"TRUNCATE TABLE orders", []string{"TRUNCATE"}},
+		{"DROP TABLE IF EXISTS orders; DELETE FROM users WHERE id = 3; DROP TABLE users", []string{"DROP", "DROP"}},
+		{"INSERT INTO orders SELECT * FROM old_orders", nil},
+		{"ALTER TABLE users DROP COLUMN email", []string{"ALTER TABLE ... DROP"}},
+		{"ALTER TABLE users ADD COLUMN note TEXT, DROP CONSTRAINT users_email_key", []string{"ALTER TABLE ... DROP"}},
+		{"ALTER TABLE users ALTER COLUMN email DROP NOT NULL", nil},
+		{"ALTER TABLE users ALTER COLUMN active DROP DEFAULT", nil},
+		{"ALTER TABLE users ADD COLUMN dropped_at TIMESTAMP", nil},
+		{"CREATE TRIGGER t AFTER INSERT ON users FOR EACH ROW BEGIN DELETE FROM audit; END", nil},
+	}
+
+	for _, tc := range testCases {
+		var reasons []string
+		for _, u := range UnboundedStatements(tc.sql) {
+			reasons = append(reasons, u.Reason)
+		}
+		if !reflect.DeepEqual(reasons, tc.expected) {
+			t.Errorf("UnboundedStatements(%q) = %q, expected %q", tc.sql, reasons, tc.expected)
+		}
+	}
+}
+
+func TestParseReader(t *testing.T) {
+	testSQL := `---
+-- @name FromReader
+SELECT * FROM users WHERE id=@id;
+---
+SET @id=1;`
+
+	file, err := Parse(strings.NewReader(testSQL), "stdin", Options{})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	queries, variables := file.Queries, file.Variables
+	if len(queries) != 1 || queries[0].Name != "FromReader" {
+		t.Fatalf("expected the FromReader query, got %v", queries)
+	}
+	if queries[0].File != "stdin" {
+		t.Errorf("expected file stdin, got %s", queries[0].File)
+	}
+	if variables["id"] != "1" {
+		t.Errorf("expected variable id=1, got %v", variables)
+	}
+}
+
+func TestParseFunctionBodies(t *testing.T) {
+	testSQL := `---
+-- @name CreateRefreshFunction
+CREATE FUNCTION refresh_stats() RETURNS void AS $$
+BEGIN
+  -- recalculate everything
+  DELETE FROM stats;
+---
+  SET @ignored=1;
+  INSERT INTO stats SELECT user_id, COUNT(*) FROM orders GROUP BY user_id;
+END;
+$$ LANGUAGE plpgsql;
+---
+
+---
+-- @name CreateArchiveProcedure
+DELIMITER $$
+CREATE PROCEDURE archive_orders()
+BEGIN
+  -- move old orders out of the way
+  INSERT INTO orders_archive SELECT * FROM orders WHERE created_at < NOW() - INTERVAL 1 YEAR;
+  DELETE FROM orders WHERE created_at < NOW() - INTERVAL 1 YEAR;
+END$$
+DELIMITER ;
+---
+
+---
+-- @name AfterBodies
+-- this comment is still dropped
+SELECT 1;
+---`
+
+	tmpFile, err := os.CreateTemp("", "bodies*.sql")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	tmpFile.WriteString(testSQL)
+	tmpFile.Close()
+
+	file, err := ParseFile(tmpFile.Name(), Options{})
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
+	}
+	queries, variables := file.Queries, file.Variables
+
+	expectedNames := []string{"CreateRefreshFunction", "CreateArchiveProcedure", "AfterBodies"}
+	if len(queries) != len(expectedNames) {
+		t.Fatalf("expected %d queries, got %d", len(expectedNames), len(queries))
+	}
+	for i, expected := range expectedNames {
+		if queries[i].Name != expected {
+			t.Errorf("expected query name %s, got %s", expected, queries[i].Name)
+		}
+	}
+
+	function := queries[0]
+	for _, expected := range []string{"-- recalculate everything", "\n---\n", "SET @ignored=1;", "$$ LANGUAGE plpgsql;"} {
+		if !strings.Contains(function.SQL, expected) {
+			t.Errorf("expected the function body to keep %q, got:\n%s", expected, function.SQL)
+		}
+	}
+	if len(function.Statements) != 1 {
+		t.Errorf("expected the function to be a single statement, got %q", function.Statements)
+	}
+	if _, ok := variables["ignored"]; ok {
+		t.Error("SET inside a function body should not define a variable")
+	}
+
+	procedure := queries[1]
+	if !strings.Contains(procedure.SQL, "-- move old orders out of the way") {
+		t.Errorf("expected the procedure body to keep its comment, got:\n%s", procedure.SQL)
+	}
+	if !strings.HasSuffix(procedure.SQL, "DELIMITER ;") {
+		t.Errorf("expected the DELIMITER lines to be kept for the mysql client, got:\n%s", procedure.SQL)
+	}
+	if len(procedure.Statements) != 1 {
+		t.Errorf("expected the procedure to be a single statement, got %q", procedure.Statements)
+	}
+
+	if queries[2].SQL != "SELECT 1;" {
+		t.Errorf("expected comments outside bodies to be dropped, got %q", queries[2].SQL)
+	}
+}
+
+func TestParseEndCase(t *testing.T) {
+	file, err := Parse(strings.NewReader(`---
+-- @name CreateGradeProcedure
+DELIMITER $$
+CREATE PROCEDURE grade(IN score INT)
+BEGIN
+  CASE
+    WHEN score > 90 THEN SELECT 'A';
+    ELSE SELECT 'B';
+  END CASE;
+END$$
+DELIMITER ;
+---
+
+---
+-- @name WipeOrders
+DELETE FROM orders;
+---`), "grade.sql", Options{})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if len(file.Queries) != 2 || file.Queries[1].Name != "WipeOrders" {
+		t.Fatalf("expected the procedure and WipeOrders, got %+v", file.Queries)
+	}
+	if strings.Contains(file.Queries[0].SQL, "DELETE") || file.Queries[1].SQL != "DELETE FROM orders;" {
+		t.Errorf("expected the DELETE to stay in WipeOrders, got %q and %q", file.Queries[0].SQL, file.Queries[1].SQL)
+	}
+}
+
+func TestParseUnclosedBlocks(t *testing.T) {
+	testCases := []struct {
+		sql      string
+		expected string
+		desc     string
+	}{
+		{"---\n-- @name Broken\nSELECT 1; /* never closed\n---\n-- @name WipeOrders\nDELETE FROM orders;\n---",
+			"line 5: query 'Broken' is still inside a /* comment", "block comment before the next query"},
+		{"---\n-- @name Broken\nCREATE FUNCTION f() AS $$\nBEGIN\n---",
+			"line 5: query 'Broken' is still inside a $$ quoted body at the end of the file", "dollar quoted body at the end"},
+		{"---\n-- @name Broken\n/* @description never closed\n---\n-- @name WipeOrders\nDELETE FROM orders;",
+			"line 3: the /* comment is never closed", "doc comment"},
+	}
+
+	for _, tc := range testCases {
+		_, err := Parse(strings.NewReader(tc.sql), "broken.sql", Options{})
+		if err == nil || !strings.Contains(err.Error(), tc.expected) {
+			t.Errorf("%s: expected an error containing %q, got %v", tc.desc, tc.expected, err)
+		}
+	}
+}
+
+func TestParseKeepComments(t *testing.T) {
+	testSQL := `---
+-- @name Hinted
+-- @keep-comments
+-- noqa: this comment should reach the database
+SELECT /*+ INDEX(users idx_active) */ * FROM users
+-- only active ones
+WHERE active = 1;
+---
+
+---
+-- @name Plain
+-- dropped
+SELECT /* kept */ 1;
+---`
+
+	tmpFile, err := os.CreateTemp("", "keepcomments*.sql")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	tmpFile.WriteString(testSQL)
+	tmpFile.Close()
+
+	file, err := ParseFile(tmpFile.Name(), Options{})
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
+	}
+	queries := file.Queries
+	if len(queries) != 2 {
+		t.Fatalf("expected 2 queries, got %d", len(queries))
+	}
+
+	expected := `-- noqa: this comment should reach the database
+SELECT /*+ INDEX(users idx_active) */ * FROM users
+-- only active ones
+WHERE active = 1;`
+	if queries[0].SQL != expected {
+		t.Errorf("expected comments to be kept:\n%s\ngot:\n%s", expected, queries[0].SQL)
+	}
+	if queries[1].SQL != "SELECT /* kept */ 1;" {
+		t.Errorf("expected comment lines to be dropped without @keep-comments, got %q", queries[1].SQL)
+	}
+
+	// the global option keeps them everywhere
+	file, err = ParseFile(tmpFile.Name(), Options{KeepComments: true})
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
+	}
+	queries = file.Queries
+	if queries[1].SQL != "-- dropped\nSELECT /* kept */ 1;" {
+		t.Errorf("expected comment lines to be kept with the global option, got %q", queries[1].SQL)
+	}
+	if strings.Contains(queries[1].SQL, "@name") {
+		t.Errorf("annotations should never be kept, got %q", queries[1].SQL)
+	}
+}
+
+func TestParseBlockCommentAnnotations(t *testing.T) {
+	testSQL := `---
+/**
+ * @name GetUser
+ * @description Fetch a single user
+ * @tags users
+ * @param user_id int the id of the user
+ */
+SELECT * FROM users WHERE id=@user_id;
+---
+
+---
+/* @name OneLiner */
+SELECT 1;
+---
+
+---
+-- @name SpansSeparator
+/*
+  this comment has no annotations and a separator in it
+---
+*/
+SELECT 2;
+---
+
+---
+/* not a header */ SELECT 3;
+-- @name InlineComment
+---`
+
+	tmpFile, err := os.CreateTemp("", "blockcomments*.sql")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	tmpFile.WriteString(testSQL)
+	tmpFile.Close()
+
+	file, err := ParseFile(tmpFile.Name(), Options{})
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
+	}
+	queries := file.Queries
+
+	expectedNames := []string{"GetUser", "OneLiner", "SpansSeparator", "InlineComment"}
+	if len(queries) != len(expectedNames) {
+		t.Fatalf("expected %d queries, got %d: %v", len(expectedNames), len(queries), queries)
+	}
+	for i, expected := range expectedNames {
+		if queries[i].Name != expected {
+			t.Errorf("expected query name %s, got %s", expected, queries[i].Name)
+		}
+	}
+
+	getUser := queries[0]
+	if getUser.Description != "Fetch a single user" || !reflect.DeepEqual(getUser.Tags, []string{"users"}) {
+		t.Errorf("unexpected annotations: %q %v", getUser.Description, getUser.Tags)
+	}
+	if !reflect.DeepEqual(getUser.Params, []Param{{Name: "user_id", Type: "int", Description: "the id of the user"}}) {
+		t.Errorf("unexpected params: %v", getUser.Params)
+	}
+	if getUser.Line != 3 {
+		t.Errorf("expected the name on line 3, got %d", getUser.Line)
+	}
+	if getUser.SQL != "SELECT * FROM users WHERE id=@user_id;" {
+		t.Errorf("expected the annotation comment to be removed, got %q", getUser.SQL)
+	}
+
+	if queries[1].SQL != "SELECT 1;" {
+		t.Errorf("unexpected sql for OneLiner: %q", queries[1].SQL)
+	}
+
+	expected := "/*\n  this comment has no annotations and a separator in it\n---\n*/\nSELECT 2;"
+	if queries[2].SQL != expected {
+		t.Errorf("expected the plain comment to be kept:\n%s\ngot:\n%s", expected, queries[2].SQL)
+	}
+
+	if queries[3].SQL != "/* not a header */ SELECT 3;" {
+		t.Errorf("unexpected sql for InlineComment: %q", queries[3].SQL)
+	}
+}
+
+func TestParseSyntaxes(t *testing.T) {
+	testCases := []struct {
+		syntax   string
+		sql      string
+		expected map[string]string
+	}{
+		{
+			"implicit",
+			`SET @id=1;
+-- @name GetUser
+SELECT * FROM users WHERE id=@id;
+
+-- @name GetOrders
+-- @description all of them
+SELECT * FROM orders;
+/* @name CountOrders */
+SELECT COUNT(*) FROM orders;
+---
+-- @name AfterSeparator
+SELECT 1;`,
+			map[string]string{
+				"GetUser":        "SELECT * FROM users WHERE id=@id;",
+				"GetOrders":      "SELECT * FROM orders;",
+				"CountOrders":    "SELECT COUNT(*) FROM orders;",
+				"AfterSeparator": "SELECT 1;",
+			},
+		},
+		{
+			"httpyac",
+			`-- ###
+-- @name GetUser
+SELECT * FROM users;
+###
+-- @name GetOrders
+SELECT * FROM orders;
+---
+-- @name Dashes
+SELECT 1;`,
+			map[string]string{
+				"GetUser":   "SELECT * FROM users;",
+				"GetOrders": "SELECT * FROM orders;",
+				"Dashes":    "SELECT 1;",
+			},
+		},
+		{
+			// httpyac and jetbrains files don't start with a separator
+			"httpyac",
+			`SET @id=1;
+-- @name First
+SELECT * FROM users WHERE id=@id;
+###
+-- @name Second
+SELECT 2;`,
+			map[string]string{
+				"First":  "SELECT * FROM users WHERE id=@id;",
+				"Second": "SELECT 2;",
+			},
+		},
+		{
+			"yesql",
+			`-- name: get-user
+-- Fetch a single user
+SELECT * FROM users WHERE id = :id;
+
+-- name: GetOrders :many
+SELECT * FROM orders;
+
+-- :name count-orders :? :1
+-- :doc Count all orders
+SELECT COUNT(*) FROM orders;`,
+			map[string]string{
+				"get-user":     "SELECT * FROM users WHERE id = :id;",
+				"GetOrders":    "SELECT * FROM orders;",
+				"count-orders": "SELECT COUNT(*) FROM orders;",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		file, err := Parse(strings.NewReader(tc.sql), "test.sql", Options{Syntax: tc.syntax})
+		if err != nil {
+			t.Fatalf("%s: Parse failed: %v", tc.syntax, err)
+		}
+		queries := file.Queries
+
+		got := make(map[string]string)
+		for _, q := range queries {
+			got[q.Name] = q.SQL
+		}
+		if !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("%s: expected %q, got %q", tc.syntax, tc.expected, got)
+		}
+	}
+}
+
+func TestParseYesqlDocstrings(t *testing.T) {
+	testSQL := `-- name: get-user
+-- Fetch a single user
+-- by their id
+SELECT * FROM users WHERE id = :id;
+
+-- :name count-orders :? :1
+-- :doc Count all orders
+SELECT COUNT(*) FROM orders;`
+
+	file, err := Parse(strings.NewReader(testSQL), "test.sql", Options{Syntax: "yesql"})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	queries := file.Queries
+	if len(queries) != 2 {
+		t.Fatalf("expected 2 queries, got %d", len(queries))
+	}
+	if queries[0].Description != "Fetch a single user by their id" {
+		t.Errorf("unexpected description: %q", queries[0].Description)
+	}
+	if queries[1].Description != "Count all orders" {
+		t.Errorf("unexpected description: %q", queries[1].Description)
+	}
+	if queries[1].Line != 6 {
+		t.Errorf("expected count-orders on line 6, got %d", queries[1].Line)
+	}
+}
+
+func TestParseDefaultSyntaxIgnoresOtherSeparators(t *testing.T) {
+	// without a syntax the other styles are just comments
+	testSQL := `-- @name NoSeparator
+SELECT 1;
+-- ###
+-- name: yesql
+SELECT 2;`
+
+	file, err := Parse(strings.NewReader(testSQL), "test.sql", Options{})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	queries := file.Queries
+	if len(queries) != 0 {
+		t.Errorf("expected 0 queries, got %d", len(queries))
+	}
+
+	if _, err := Parse(strings.NewReader(testSQL), "test.sql", Options{Syntax: "nope"}); err == nil {
+		t.Error("expected an error for an unknown syntax, got none")
+	}
+}
+
+func TestYesqlResult(t *testing.T) {
+	testCases := []struct {
+		suffix   string
+		hints    []string
+		expected string
+	}{
+		{"", []string{":one"}, "one"},
+		{"", []string{":many"}, "many"},
+		{"", []string{":execrows"}, "execrows"},
+		{"", []string{":?", ":1"}, "one"},
+		{"", []string{":?", ":*"}, "many"},
+		{"", []string{":!", ":n"}, "execrows"},
+		{"", []string{":!"}, "exec"},
+		{"", []string{":i!"}, "execlastid"},
+		{"", []string{":query"}, "many"},
+		{"!", nil, "exec"},
+		{"<!", nil, "execlastid"},
+		{"", nil, ""},
+	}
+
+	for _, tc := range testCases {
+		if got := yesqlResult(tc.suffix, tc.hints); got != tc.expected {
+			t.Errorf("yesqlResult(%q, %v) = %q, expected %q", tc.suffix, tc.hints, got, tc.expected)
+		}
+	}
+}
+
+func TestReferencedVariables(t *testing.T) {
+	sql := `SELECT * FROM users
+WHERE id=@user_id AND email='bob@example.com'
+AND @@session.time_zone='UTC'
+AND note NOT LIKE '%@gmail.com' -- or @unused
+OR parent_id=@user_id
+LIMIT @lim;`
+
+	expected := []string{"user_id", "lim"}
+	if got := ReferencedVariables(sql); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestBind(t *testing.T) {
+	sql := "SELECT * FROM t WHERE a = @a AND b = @b AND c = @a AND email = 'x@y.com'"
+
+	bound, args := Bind(sql, "?")
+	if bound != "SELECT * FROM t WHERE a = ? AND b = ? AND c = ? AND email = 'x@y.com'" {
+		t.Errorf("unexpected sql: %s", bound)
+	}
+	if !reflect.DeepEqual(args, []string{"a", "b", "a"}) {
+		t.Errorf("unexpected args: %v", args)
+	}
+
+	bound, args = Bind(sql, "$")
+	if bound != "SELECT * FROM t WHERE a = $1 AND b = $2 AND c = $1 AND email = 'x@y.com'" {
+		t.Errorf("unexpected sql: %s", bound)
+	}
+	if !reflect.DeepEqual(args, []string{"a", "b"}) {
+		t.Errorf("unexpected args: %v", args)
+	}
+}
+
+func TestAllParams(t *testing.T) {
+	q := Query{
+		Name:   "GetUser",
+		SQL:    "SELECT * FROM users WHERE id=@user_id AND status=@status",
+		Params: []Param{{Name: "user_id", Type: "int"}},
+	}
+
+	expected := []Param{{Name: "user_id", Type: "int"}, {Name: "status"}}
+	if got := q.AllParams(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestParseFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"queries/users.sql": {Data: []byte("SET @id=1;\n---\n-- @name GetUser\nSELECT * FROM users WHERE id=@id;\n---")},
+	}
+
+	file, err := ParseFS(fsys, "queries/users.sql", Options{})
+	if err != nil {
+		t.Fatalf("ParseFS failed: %v", err)
+	}
+	q, ok := file.Query("GetUser")
+	if !ok {
+		t.Fatalf("expected the GetUser query, got %v", file.Queries)
+	}
+	if q.File != "queries/users.sql" || q.Line != 3 {
+		t.Errorf("expected location queries/users.sql:3, got %s:%d", q.File, q.Line)
+	}
+
+	sql, err := Interpolate(q.SQL, file.Variables)
+	if err != nil {
+		t.Fatalf("Interpolate failed: %v", err)
+	}
+	if sql != "SELECT * FROM users WHERE id=1;" {
+		t.Errorf("unexpected sql: %q", sql)
+	}
+
+	if _, ok := file.Query("getuser"); ok {
+		t.Error("Query should only match exact names")
+	}
+	if _, err := ParseFS(fsys, "missing.sql", Options{}); err == nil {
+		t.Error("expected an error for a missing file, got none")
+	}
+}
@@ -0,0 +1,130 @@
+package queryfile
+
+import (
+	"fmt"
+	"io/fs"
+)
+
+// Registry holds the queries of every file loaded from a file system by
+// name, so code can run the same queries the cli does
+//
+//	//go:embed queries/*.sql
+//	var queriesFS embed.FS
+//
+//	var queries = queryfile.MustLoad(queriesFS, "queries/*.sql", queryfile.Options{})
+//
+//	sql, args, err := queries.Bind("GetUser", map[string]any{"user_id": id}, "?")
+//	row := db.QueryRowContext(ctx, sql, args...)
+type Registry struct {
+	queries map[string]Query
+	// variables set in each file, by file name
+	variables map[string]map[string]string
+	names     []string
+}
+
+// Load parses every file in fsys matching the glob pattern. query names have
+// to be unique across all the files.
+func Load(fsys fs.FS, pattern string, opts Options) (*Registry, error) {
+	paths, err := fs.Glob(fsys, pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no query files match %q", pattern)
+	}
+
+	r := &Registry{
+		queries:   make(map[string]Query),
+		variables: make(map[string]map[string]string),
+	}
+	for _, path := range paths {
+		file, err := ParseFS(fsys, path, opts)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		r.variables[path] = file.Variables
+		for _, q := range file.Queries {
+			if existing, ok := r.queries[q.Name]; ok {
+				return nil, fmt.Errorf("query '%s' is defined twice, in %s:%d and %s:%d",
+					q.Name, existing.File, existing.Line, q.File, q.Line)
+			}
+			r.queries[q.Name] = q
+			r.names = append(r.names, q.Name)
+		}
+	}
+	return r, nil
+}
+
+// MustLoad is like Load but panics on errors, for loading embedded files
+// into package variables
+func MustLoad(fsys fs.FS, pattern string, opts Options) *Registry {
+	r, err := Load(fsys, pattern, opts)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// Query returns the query with the given name
+func (r *Registry) Query(name string) (Query, bool) {
+	q, ok := r.queries[name]
+	return q, ok
+}
+
+// Names returns the names of all queries in the order they were loaded
+func (r *Registry) Names() []string {
+	return append([]string{}, r.names...)
+}
+
+// SQL returns the sql of a query with the variables of its file interpolated,
+// vars override the values set in the file and can be nil. the values are
+// pasted into the sql as they are, like the cli does, so never pass values
+// that come from users, use Bind for those.
+func (r *Registry) SQL(name string, vars map[string]string) (string, error) {
+	q, ok := r.queries[name]
+	if !ok {
+		return "", fmt.Errorf("query '%s' not found", name)
+	}
+
+	variables := make(map[string]string)
+	for k, v := range r.variables[q.File] {
+		variables[k] = v
+	}
+	for k, v := range vars {
+		variables[k] = v
+	}
+	return Interpolate(q.SQL, variables)
+}
+
+// Bind returns the sql of a query with driver placeholders, ? for mysql and
+// sqlite or $ for postgres, for the variables in args and the arguments to
+// pass with it, so the values never end up in the sql. variables of the file
+// that aren't in args are interpolated like SQL does, any others are an error.
+func (r *Registry) Bind(name string, args map[string]any, placeholder string) (string, []any, error) {
+	q, ok := r.queries[name]
+	if !ok {
+		return "", nil, fmt.Errorf("query '%s' not found", name)
+	}
+
+	variables := make(map[string]string)
+	for k, v := range r.variables[q.File] {
+		if _, ok := args[k]; !ok {
+			variables[k] = v
+		}
+	}
+	sql, err := Interpolate(q.SQL, variables)
+	if err != nil {
+		return "", nil, err
+	}
+
+	sql, names := Bind(sql, placeholder)
+	values := make([]any, 0, len(names))
+	for _, name := range names {
+		value, ok := args[name]
+		if !ok {
+			return "", nil, fmt.Errorf("query '%s' needs a value for @%s", q.Name, name)
+		}
+		values = append(values, value)
+	}
+	return sql, values, nil
+}
@@ -0,0 +1,115 @@
+package queryfile
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"queries/users.sql": {Data: []byte(`SET @status="active";
+---
+-- @name GetUsers
+SELECT * FROM users WHERE status=@status;
+---`)},
+		"queries/orders.sql": {Data: []byte(`SET @status="shipped";
+---
+-- @name GetOrders
+SELECT * FROM orders WHERE status=@status AND user_id=@user_id;
+---`)},
+		"queries/notes.txt": {Data: []byte("not a query file")},
+	}
+
+	r, err := Load(fsys, "queries/*.sql", Options{})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	// fs.Glob returns paths in lexical order
+	if names := r.Names(); !reflect.DeepEqual(names, []string{"GetOrders", "GetUsers"}) {
+		t.Errorf("unexpected names: %v", names)
+	}
+	if q, ok := r.Query("GetUsers"); !ok || q.File != "queries/users.sql" {
+		t.Errorf("expected GetUsers from queries/users.sql, got %v %v", q, ok)
+	}
+
+	// each query uses the variables of its own file
+	sql, err := r.SQL("GetUsers", nil)
+	if err != nil {
+		t.Fatalf("SQL failed: %v", err)
+	}
+	if sql != `SELECT * FROM users WHERE status="active";` {
+		t.Errorf("unexpected sql: %s", sql)
+	}
+	sql, err = r.SQL("GetOrders", map[string]string{"user_id": "7"})
+	if err != nil {
+		t.Fatalf("SQL failed: %v", err)
+	}
+	if sql != `SELECT * FROM orders WHERE status="shipped" AND user_id=7;` {
+		t.Errorf("unexpected sql: %s", sql)
+	}
+
+	if _, err := r.SQL("Missing", nil); err == nil {
+		t.Error("expected an error for a missing query, got none")
+	}
+
+	// bound values stay out of the sql
+	sql, args, err := r.Bind("GetOrders", map[string]any{"user_id": "7 OR 1=1"}, "$")
+	if err != nil {
+		t.Fatalf("Bind failed: %v", err)
+	}
+	if sql != `SELECT * FROM orders WHERE status="shipped" AND user_id=$1;` || !reflect.DeepEqual(args, []any{"7 OR 1=1"}) {
+		t.Errorf("unexpected sql %s and args %v", sql, args)
+	}
+	sql, args, err = r.Bind("GetOrders", map[string]any{"user_id": 7, "status": "lost"}, "?")
+	if err != nil {
+		t.Fatalf("Bind failed: %v", err)
+	}
+	if sql != `SELECT * FROM orders WHERE status=? AND user_id=?;` || !reflect.DeepEqual(args, []any{"lost", 7}) {
+		t.Errorf("unexpected sql %s and args %v", sql, args)
+	}
+	if _, _, err := r.Bind("GetOrders", nil, "?"); err == nil || !strings.Contains(err.Error(), "@user_id") {
+		t.Errorf("expected an error for the missing user_id, got %v", err)
+	}
+	if _, _, err := r.Bind("Missing", nil, "?"); err == nil {
+		t.Error("expected an error for a missing query, got none")
+	}
+}
+
+func TestLoadDuplicates(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.sql": {Data: []byte("---\n-- @name GetUser\nSELECT 1;\n---")},
+		"b.sql": {Data: []byte("---\n-- @name Other\nSELECT 2;\n---\n-- @name GetUser\nSELECT 3;\n---")},
+	}
+
+	_, err := Load(fsys, "*.sql", Options{})
+	if err == nil {
+		t.Fatal("expected an error for duplicate names, got none")
+	}
+	if !strings.Contains(err.Error(), "a.sql:2") || !strings.Contains(err.Error(), "b.sql:5") {
+		t.Errorf("expected both locations in the error, got %v", err)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	fsys := fstest.MapFS{"a.sql": {Data: []byte("---\n-- @name GetUser\nSELECT 1;\n---")}}
+
+	if _, err := Load(fsys, "*.pgsql", Options{}); err == nil {
+		t.Error("expected an error when nothing matches, got none")
+	}
+	if _, err := Load(fsys, "[", Options{}); err == nil {
+		t.Error("expected an error for a bad pattern, got none")
+	}
+	if _, err := Load(fsys, "*.sql", Options{Syntax: "nope"}); err == nil {
+		t.Error("expected an error for an unknown syntax, got none")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected MustLoad to panic")
+		}
+	}()
+	MustLoad(fsys, "*.pgsql", Options{})
+}
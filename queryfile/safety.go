@@ -0,0 +1,189 @@
+package queryfile
+
+import "strings"
+
+// statement kinds returned by Kind
+const (
+	KindRead  = "read"
+	KindWrite = "write"
+	KindDDL   = "ddl"
+)
+
+// ContainsSchemaChanges reports whether sql creates, alters, drops or
+// truncates tables, databases or schemas
+func ContainsSchemaChanges(sql string) bool {
+	sql = strings.ToLower(sql)
+	schemaKeywords := []string{
+		"drop table", "drop database", "drop schema",
+		"alter table", "alter database", "alter schema",
+		"create table", "create database", "create schema",
+		"truncate table", "truncate",
+	}
+
+	for _, keyword := range schemaKeywords {
+		if strings.Contains(sql, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// ContainsUpdates reports whether sql inserts, updates or deletes rows
+func ContainsUpdates(sql string) bool {
+	sql = strings.ToLower(sql)
+	updateKeywords := []string{"update ", "delete ", "delete from", "insert"}
+
+	for _, keyword := range updateKeywords {
+		if strings.Contains(sql, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// Kind classifies sql as KindDDL, KindWrite or KindRead
+func Kind(sql string) string {
+	switch {
+	case ContainsSchemaChanges(sql):
+		return KindDDL
+	case ContainsUpdates(sql):
+		return KindWrite
+	default:
+		return KindRead
+	}
+}
+
+// UnboundedStatement is a statement that changes a whole table instead of
+// some of its rows
+type UnboundedStatement struct {
+	Statement string
+	// Reason is what makes it unbounded, like "DELETE without WHERE"
+	Reason string
+}
+
+// UnboundedStatements returns the statements in sql that update or delete
+// without a WHERE clause, truncate, drop, or alter something to drop a part
+// of it like a column
+func UnboundedStatements(sql string) []UnboundedStatement {
+	var unbounded []UnboundedStatement
+	for _, statement := range SplitStatements(sql) {
+		if reason := unboundedReason(statement); reason != "" {
+			unbounded = append(unbounded, UnboundedStatement{Statement: statement, Reason: reason})
+		}
+	}
+	return unbounded
+}
+
+// unboundedReason says why a statement is unbounded, or returns "" when it
+// isn't
+func unboundedReason(statement string) string {
+	words := topLevelWords(statement)
+	if len(words) == 0 {
+		return ""
+	}
+	verb := words[0]
+	if verb == "with" {
+		// the statement itself comes after its common table expressions
+		for _, word := range words[1:] {
+			if word == "select" || word == "insert" || word == "update" || word == "delete" {
+				verb = word
+				break
+			}
+		}
+	}
+
+	switch verb {
+	case "truncate":
+		return "TRUNCATE"
+	case "drop":
+		return "DROP"
+	case "alter":
+		for i, word := range words {
+			if word != "drop" {
+				continue
+			}
+			// DROP DEFAULT, DROP NOT NULL etc don't lose any data
+			if i+1 < len(words) && (words[i+1] == "default" || words[i+1] == "not" || words[i+1] == "identity" || words[i+1] == "expression") {
+				continue
+			}
+			return "ALTER " + strings.ToUpper(words[1]) + " ... DROP"
+		}
+	case "update", "delete":
+		for _, word := range words {
+			if word == "where" {
+				return ""
+			}
+		}
+		return strings.ToUpper(verb) + " without WHERE"
+	}
+	return ""
+}
+
+// topLevelWords returns the lowercased words of a statement that aren't in
+// strings, comments or parentheses, so a WHERE in a subquery doesn't count
+// for the statement around it
+func topLevelWords(statement string) []string {
+	var words []string
+	depth := 0
+	for i := 0; i < len(statement); {
+		rest := statement[i:]
+		c := statement[i]
+		switch {
+		case strings.HasPrefix(rest, "--"):
+			end := strings.IndexByte(rest, '\n')
+			if end < 0 {
+				return words
+			}
+			i += end + 1
+		case strings.HasPrefix(rest, "/*"):
+			end := strings.Index(rest[2:], "*/")
+			if end < 0 {
+				return words
+			}
+			i += end + 4
+		case c == '\'' || c == '"' || c == '`':
+			i++
+			for i < len(statement) {
+				if statement[i] == '\\' && c != '`' {
+					i += 2
+					continue
+				}
+				if statement[i] == c {
+					if i+1 < len(statement) && statement[i+1] == c {
+						// doubled quotes are escaped quotes
+						i += 2
+						continue
+					}
+					break
+				}
+				i++
+			}
+			i++
+		case c == '$' && dollarTagRegex.MatchString(rest) && (i == 0 || !IsWordByte(statement[i-1])):
+			tag := dollarTagRegex.FindString(rest)
+			end := strings.Index(rest[len(tag):], tag)
+			if end < 0 {
+				return words
+			}
+			i += len(tag) + end + len(tag)
+		case c == '(':
+			depth++
+			i++
+		case c == ')':
+			depth--
+			i++
+		case IsWordByte(c):
+			j := i
+			for j < len(statement) && IsWordByte(statement[j]) {
+				j++
+			}
+			if depth == 0 {
+				words = append(words, strings.ToLower(statement[i:j]))
+			}
+			i = j
+		default:
+			i++
+		}
+	}
+	return words
+}
@@ -0,0 +1,226 @@
+package queryfile
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	delimiterRegex = regexp.MustCompile(`(?i)^\s*DELIMITER\s+(\S+)\s*$`)
+	dollarTagRegex = regexp.MustCompile(`^\$[A-Za-z_]*\$`)
+)
+
+// sqlSplitter splits sql into statements. it keeps track of strings,
+// comments, postgres dollar quoted bodies, BEGIN ... END blocks and mysql
+// DELIMITER changes so only real statement ends count. feed it one line at a
+// time with feedLine and collect the statements with finish.
+type sqlSplitter struct {
+	delimiter    string
+	quote        byte   // ', " or ` while inside a quoted string or identifier
+	dollarTag    string // $tag$ of the dollar quoted body we're in
+	blockComment bool
+	depth        int // BEGIN/CASE ... END nesting
+	pendingBegin bool
+	pendingEnd   bool
+	current      strings.Builder
+	hasCode      bool // current statement has more than whitespace and comments
+	statements   []string
+}
+
+func newSQLSplitter() *sqlSplitter {
+	return &sqlSplitter{delimiter: ";"}
+}
+
+// SplitStatements returns the statements in sql without their delimiters
+func SplitStatements(sql string) []string {
+	s := newSQLSplitter()
+	for _, line := range strings.Split(sql, "\n") {
+		s.feedLine(line)
+	}
+	return s.finish()
+}
+
+// feedLine consumes the next line of sql (without its newline)
+func (s *sqlSplitter) feedLine(line string) {
+	if !s.blockComment && s.quote == 0 && s.dollarTag == "" {
+		if matches := delimiterRegex.FindStringSubmatch(line); matches != nil {
+			s.delimiter = matches[1]
+			return
+		}
+	}
+
+	text := line + "\n"
+	for i := 0; i < len(text); {
+		rest := text[i:]
+		c := text[i]
+
+		switch {
+		case s.blockComment:
+			if strings.HasPrefix(rest, "*/") {
+				s.blockComment = false
+				s.current.WriteString("*/")
+				i += 2
+				continue
+			}
+		case s.quote != 0:
+			if c == '\\' && s.quote != '`' && i+1 < len(text) {
+				s.current.WriteString(text[i : i+2])
+				i += 2
+				continue
+			}
+			if c == s.quote {
+				if i+1 < len(text) && text[i+1] == s.quote {
+					// doubled quotes are escaped quotes
+					s.current.WriteString(text[i : i+2])
+					i += 2
+					continue
+				}
+				s.quote = 0
+			}
+		case s.dollarTag != "":
+			if strings.HasPrefix(rest, s.dollarTag) {
+				s.current.WriteString(s.dollarTag)
+				i += len(s.dollarTag)
+				s.dollarTag = ""
+				continue
+			}
+		case IsWordByte(c) && (i == 0 || !IsWordByte(text[i-1])):
+			j := i
+			for j < len(text) && IsWordByte(text[j]) {
+				j++
+			}
+			s.keyword(strings.ToUpper(text[i:j]))
+			s.current.WriteString(text[i:j])
+			s.hasCode = true
+			i = j
+			continue
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			// whitespace doesn't change anything
+		case strings.HasPrefix(rest, "--"):
+			// the rest of the line is a comment
+			s.current.WriteString(rest)
+			return
+		case strings.HasPrefix(rest, "/*"):
+			s.blockComment = true
+			s.current.WriteString("/*")
+			i += 2
+			continue
+		default:
+			// anything else settles a BEGIN or END we were unsure about
+			s.resolvePending("")
+			if s.depth == 0 && strings.HasPrefix(rest, s.delimiter) {
+				s.endStatement()
+				i += len(s.delimiter)
+				continue
+			}
+			s.hasCode = true
+			if c == '\'' || c == '"' || c == '`' {
+				s.quote = c
+			} else if tag := dollarTagRegex.FindString(rest); tag != "" && (i == 0 || !IsWordByte(text[i-1])) {
+				s.dollarTag = tag
+				s.current.WriteString(tag)
+				i += len(tag)
+				continue
+			}
+		}
+
+		s.current.WriteByte(c)
+		i++
+	}
+}
+
+// keyword keeps track of BEGIN ... END and CASE ... END blocks
+func (s *sqlSplitter) keyword(word string) {
+	if s.resolvePending(word) {
+		// the word belonged to the END before it
+		return
+	}
+	switch word {
+	case "BEGIN":
+		// could be a block or a transaction, the next word tells
+		s.pendingBegin = true
+	case "CASE":
+		s.depth++
+	case "END":
+		// could be END IF, END LOOP etc which we don't count
+		s.pendingEnd = true
+	}
+}
+
+// resolvePending settles a BEGIN or END once we know the word after it, an
+// empty word means punctuation or the end of the input. it reports whether
+// next was part of an END, like the CASE of END CASE.
+func (s *sqlSplitter) resolvePending(next string) bool {
+	if s.pendingBegin {
+		s.pendingBegin = false
+		switch next {
+		case "", "TRANSACTION", "WORK", "DEFERRED", "IMMEDIATE", "EXCLUSIVE", "ISOLATION", "READ":
+			// BEGIN; and friends start a transaction
+		default:
+			s.depth++
+		}
+	}
+	if s.pendingEnd {
+		s.pendingEnd = false
+		switch next {
+		case "IF", "LOOP", "WHILE", "REPEAT":
+			// their blocks aren't counted
+			return true
+		case "CASE":
+			// closes the CASE that was counted
+			if s.depth > 0 {
+				s.depth--
+			}
+			return true
+		default:
+			if s.depth > 0 {
+				s.depth--
+			}
+		}
+	}
+	return false
+}
+
+func (s *sqlSplitter) endStatement() {
+	if s.hasCode {
+		s.statements = append(s.statements, strings.TrimSpace(s.current.String()))
+	}
+	s.current.Reset()
+	s.hasCode = false
+}
+
+// inBody reports whether the next line is inside a dollar quoted body, a
+// block comment, a BEGIN ... END block or a statement using a custom
+// DELIMITER, where lines that look like comments or separators are really
+// part of the sql
+func (s *sqlSplitter) inBody() bool {
+	return s.openBlock() != ""
+}
+
+// openBlock describes the body or comment the next line is inside of, for
+// errors, or returns "" when there's none
+func (s *sqlSplitter) openBlock() string {
+	switch {
+	case s.dollarTag != "":
+		return "a " + s.dollarTag + " quoted body"
+	case s.blockComment:
+		return "a /* comment"
+	case s.depth > 0:
+		return "a BEGIN ... END block"
+	case s.delimiter != ";" && s.hasCode:
+		return "a statement that doesn't end with " + s.delimiter
+	}
+	return ""
+}
+
+// finish returns all statements including a last one without a delimiter
+func (s *sqlSplitter) finish() []string {
+	s.resolvePending("")
+	s.endStatement()
+	return s.statements
+}
+
+// IsWordByte reports whether c can be part of an sql keyword or identifier
+func IsWordByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
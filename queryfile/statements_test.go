@@ -0,0 +1,97 @@
+package queryfile
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	testCases := []struct {
+		sql      string
+		expected []string
+		desc     string
+	}{
+		{
+			"DROP TABLE IF EXISTS orders;\nDROP TABLE IF EXISTS users;",
+			[]string{"DROP TABLE IF EXISTS orders", "DROP TABLE IF EXISTS users"},
+			"one statement per line",
+		},
+		{
+			"CREATE PROCEDURE p() BEGIN CASE x WHEN 1 THEN SELECT 1; END CASE; END;\nDELETE FROM orders;",
+			[]string{"CREATE PROCEDURE p() BEGIN CASE x WHEN 1 THEN SELECT 1; END CASE; END", "DELETE FROM orders"},
+			"END CASE closes its CASE",
+		},
+		{
+			"SELECT 1; SELECT 2",
+			[]string{"SELECT 1", "SELECT 2"},
+			"last statement without a delimiter",
+		},
+		{
+			"INSERT INTO notes VALUES ('a; b', \"c;d\", 'it''s; fine', 'esc\\'; aped');\nSELECT `weird;name` FROM t;",
+			[]string{"INSERT INTO notes VALUES ('a; b', \"c;d\", 'it''s; fine', 'esc\\'; aped')", "SELECT `weird;name` FROM t"},
+			"semicolons inside strings and identifiers",
+		},
+		{
+			"SELECT 1; -- trailing; comment\n/* block; comment */ SELECT 2;\n-- just a comment;",
+			[]string{"SELECT 1", "-- trailing; comment\n/* block; comment */ SELECT 2"},
+			"semicolons inside comments and comment only leftovers",
+		},
+		{
+			"CREATE TRIGGER audit AFTER INSERT ON users\nBEGIN\n  INSERT INTO log VALUES (NEW.id);\n  UPDATE stats SET n = n + 1;\nEND;\nSELECT 1;",
+			[]string{"CREATE TRIGGER audit AFTER INSERT ON users\nBEGIN\n  INSERT INTO log VALUES (NEW.id);\n  UPDATE stats SET n = n + 1;\nEND", "SELECT 1"},
+			"BEGIN ... END bodies",
+		},
+		{
+			"BEGIN;\nUPDATE users SET active = 0;\nCOMMIT;",
+			[]string{"BEGIN", "UPDATE users SET active = 0", "COMMIT"},
+			"BEGIN as a transaction",
+		},
+		{
+			"BEGIN TRANSACTION;\nSELECT CASE WHEN a THEN 1 ELSE 2 END FROM t;\nCOMMIT;",
+			[]string{"BEGIN TRANSACTION", "SELECT CASE WHEN a THEN 1 ELSE 2 END FROM t", "COMMIT"},
+			"CASE ... END expressions",
+		},
+		{
+			"CREATE FUNCTION f() RETURNS int AS $$\nBEGIN\n  -- comment; here\n  RETURN 1;\nEND;\n$$ LANGUAGE plpgsql;\nSELECT f();",
+			[]string{"CREATE FUNCTION f() RETURNS int AS $$\nBEGIN\n  -- comment; here\n  RETURN 1;\nEND;\n$$ LANGUAGE plpgsql", "SELECT f()"},
+			"dollar quoted bodies",
+		},
+		{
+			"CREATE FUNCTION g() RETURNS text AS $body$ SELECT 'a;b' $body$ LANGUAGE sql; SELECT $1;",
+			[]string{"CREATE FUNCTION g() RETURNS text AS $body$ SELECT 'a;b' $body$ LANGUAGE sql", "SELECT $1"},
+			"tagged dollar quotes and placeholders",
+		},
+		{
+			"DELIMITER $$\nCREATE PROCEDURE p()\nBEGIN\n  IF 1 THEN\n    SELECT 1;\n  END IF;\n  SELECT 2;\nEND$$\nDELIMITER ;\nCALL p();",
+			[]string{"CREATE PROCEDURE p()\nBEGIN\n  IF 1 THEN\n    SELECT 1;\n  END IF;\n  SELECT 2;\nEND", "CALL p()"},
+			"DELIMITER changes",
+		},
+		{
+			"DELIMITER //\nCREATE PROCEDURE q() SELECT 1; SELECT 2//\nDELIMITER ;",
+			[]string{"CREATE PROCEDURE q() SELECT 1; SELECT 2"},
+			"custom delimiter without BEGIN",
+		},
+	}
+
+	for _, tc := range testCases {
+		if got := SplitStatements(tc.sql); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("%s:\nexpected %q\ngot      %q", tc.desc, tc.expected, got)
+		}
+	}
+}
+
+func TestParseStatements(t *testing.T) {
+	file, err := Parse(strings.NewReader(`---
+-- @name CleanupTestData
+DROP TABLE IF EXISTS orders;
+DROP TABLE IF EXISTS users;
+---`), "cleanup.sql", Options{})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	expected := []string{"DROP TABLE IF EXISTS orders", "DROP TABLE IF EXISTS users"}
+	if len(file.Queries) != 1 || !reflect.DeepEqual(file.Queries[0].Statements, expected) {
+		t.Errorf("expected statements %q, got %+v", expected, file.Queries)
+	}
+}
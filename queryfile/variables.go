@@ -0,0 +1,146 @@
+package queryfile
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	variableRefRegex = regexp.MustCompile(`@(\w+)`)
+	// an @variable for ReplaceParams
+	variableParamRegex = regexp.MustCompile(`^@(\w+)`)
+)
+
+// Interpolate replaces @name references in sql with the values of variables,
+// references to unknown variables are left alone
+func Interpolate(sql string, variables map[string]string) (string, error) {
+	result := variableRefRegex.ReplaceAllStringFunc(sql, func(match string) string {
+		// Extract variable name from @variable_name
+		varName := variableRefRegex.FindStringSubmatch(match)[1]
+
+		if value, exists := variables[varName]; exists {
+			// Return the value as-is (preserving original quoting)
+			return value
+		}
+
+		// If variable not found, return the original match
+		return match
+	})
+
+	return result, nil
+}
+
+// Bind replaces @variables with driver placeholders, ? for mysql and sqlite
+// or $ for postgres, and returns the variable for each argument. ?
+// placeholders need an argument every time a variable is used, $1 style ones
+// are numbered once per variable. unlike Interpolate it leaves strings and
+// comments alone.
+func Bind(sql, placeholder string) (string, []string) {
+	var args []string
+	numbers := make(map[string]int)
+	bound := ReplaceParams(sql, []*regexp.Regexp{variableParamRegex}, func(matches []string) string {
+		name := matches[1]
+		if placeholder == "?" {
+			args = append(args, name)
+			return "?"
+		}
+		if _, ok := numbers[name]; !ok {
+			args = append(args, name)
+			numbers[name] = len(args)
+		}
+		return fmt.Sprintf("$%d", numbers[name])
+	})
+	return bound, args
+}
+
+// ReplaceParams replaces parameters matching one of the patterns, which have
+// to be anchored with ^. strings, quoted identifiers and comments are left
+// alone, and so are matches right after a word character, @ or : so emails,
+// @@system_vars and ::casts don't count.
+func ReplaceParams(sql string, patterns []*regexp.Regexp, replace func(matches []string) string) string {
+	var b strings.Builder
+	for i := 0; i < len(sql); {
+		rest := sql[i:]
+		c := sql[i]
+
+		// copy strings and comments as they are
+		var skip int
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end := strings.IndexByte(rest[1:], c)
+			skip = len(rest)
+			if end >= 0 {
+				skip = end + 2
+			}
+		case strings.HasPrefix(rest, "--"):
+			skip = len(rest)
+			if end := strings.IndexByte(rest, '\n'); end >= 0 {
+				skip = end
+			}
+		case strings.HasPrefix(rest, "/*"):
+			skip = len(rest)
+			if end := strings.Index(rest, "*/"); end >= 0 {
+				skip = end + 2
+			}
+		}
+		if skip > 0 {
+			b.WriteString(rest[:skip])
+			i += skip
+			continue
+		}
+
+		if i == 0 || !(IsWordByte(sql[i-1]) || sql[i-1] == '@' || sql[i-1] == ':') {
+			replaced := false
+			for _, pattern := range patterns {
+				if matches := pattern.FindStringSubmatch(rest); matches != nil {
+					b.WriteString(replace(matches))
+					i += len(matches[0])
+					replaced = true
+					break
+				}
+			}
+			if replaced {
+				continue
+			}
+		}
+
+		b.WriteByte(c)
+		i++
+	}
+	return b.String()
+}
+
+// ReferencedVariables returns the @variable names used in sql, in order of
+// first use. like Bind it skips strings and comments, emails and
+// @@system_vars.
+func ReferencedVariables(sql string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	ReplaceParams(sql, []*regexp.Regexp{variableParamRegex}, func(matches []string) string {
+		if !seen[matches[1]] {
+			names = append(names, matches[1])
+			seen[matches[1]] = true
+		}
+		return matches[0]
+	})
+	return names
+}
+
+// AllParams returns the declared @param annotations followed by any
+// undeclared variables the query references
+func (q Query) AllParams() []Param {
+	params := []Param{}
+	seen := make(map[string]bool)
+	for _, p := range q.Params {
+		params = append(params, p)
+		seen[p.Name] = true
+	}
+	for _, name := range ReferencedVariables(q.SQL) {
+		if !seen[name] {
+			params = append(params, Param{Name: name})
+			seen[name] = true
+		}
+	}
+	return params
+}
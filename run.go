@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kalli/sqlyac/queryfile"
+)
+
+// runOptions are the flags that change what happens to the chosen queries
+type runOptions struct {
+	confirm bool
+	explain bool
+	analyze bool
+	dryRun  bool
+	tx      bool
+	dialect string
+	// conn runs the queries, without one they're printed
+	conn *Connection
+	// confirmed is set when the whole plan was already confirmed at once
+	confirmed bool
+	// history records what happened to each query, nil doesn't
+	history *historyLog
+	// yesIMeanProd answers the questions of a protected connection when
+	// there's no terminal to ask on
+	yesIMeanProd bool
+}
+
+// protected reports whether the queries run on a protected connection
+func (o runOptions) protected() bool {
+	return o.conn != nil && o.conn.Protected
+}
+
+// saysYes reports whether --yes-i-mean-prod answers the questions instead of
+// a person, someone at a terminal still has to type the name
+func (o runOptions) saysYes() bool {
+	return o.protected() && o.yesIMeanProd && !promptIsTerminal()
+}
+
+// ask asks a yes/no question, on a protected connection the answer is its
+// name or one of names
+func (o runOptions) ask(question string, names ...string) bool {
+	switch {
+	case !o.protected():
+		return askYesNo(question)
+	case o.saysYes():
+		fmt.Fprintf(os.Stderr, "\n%s yes, --yes-i-mean-prod\n", question)
+		return true
+	}
+	return askTyped(fmt.Sprintf("%s %s is protected,", question, o.conn.Name), append([]string{o.conn.Name}, names...)...)
+}
+
+// mode names what happens to the queries, for the history
+func (o runOptions) mode() string {
+	switch {
+	case o.explain && o.analyze:
+		return "explain analyze"
+	case o.explain:
+		return "explain"
+	case o.dryRun:
+		return "dry-run"
+	case o.tx:
+		return "tx"
+	case o.conn != nil:
+		return "run"
+	}
+	return "print"
+}
+
+// queryResult is what happened to a query, for the summary
+type queryResult struct {
+	name   string
+	status string
+}
+
+// selectQueries returns the queries picked by name, by tag or with --all in
+// the order they're in the file, whatever order they were asked for in
+func selectQueries(queries []queryfile.Query, names []string, all bool, tag string) ([]queryfile.Query, error) {
+	chosen := make(map[string]bool)
+	for _, name := range names {
+		q, guessed, err := findQuery(queries, name)
+		if err != nil {
+			return nil, err
+		}
+		if guessed {
+			if err := confirmGuess(name, q); err != nil {
+				return nil, err
+			}
+		}
+		chosen[q.Name] = true
+	}
+
+	var selected []queryfile.Query
+	for _, q := range queries {
+		if chosen[q.Name] || all || (tag != "" && hasTag(q, tag)) {
+			selected = append(selected, q)
+		}
+	}
+	if len(selected) == 0 && tag != "" {
+		return nil, fmt.Errorf("no queries tagged '%s'", tag)
+	}
+	return selected, nil
+}
+
+// planQueries adds the queries the selected ones depend on and orders them
+// so every query runs after its dependencies. with withDeps false only the
+// selected queries are kept, for when the dependencies already ran. it also
+// reports whether any query had dependencies.
+func planQueries(queries, selected []queryfile.Query, withDeps bool) ([]queryfile.Query, bool, error) {
+	var names []string
+	hasDeps := false
+	for _, q := range selected {
+		names = append(names, q.Name)
+		hasDeps = hasDeps || len(q.Depends) > 0
+	}
+	if !hasDeps {
+		return selected, false, nil
+	}
+
+	plan, err := queryfile.Plan(queries, names...)
+	if err != nil {
+		return nil, true, err
+	}
+	if !withDeps {
+		chosen := make(map[string]bool)
+		for _, name := range names {
+			chosen[name] = true
+		}
+		var kept []queryfile.Query
+		for _, q := range plan {
+			if chosen[q.Name] {
+				kept = append(kept, q)
+			}
+		}
+		plan = kept
+	}
+	return plan, true, nil
+}
+
+// confirmPlan prints the queries that are about to run, with the ones that
+// were pulled in as dependencies marked, and asks once for all of them
+func confirmPlan(plan, selected []queryfile.Query, opts runOptions) bool {
+	requested := make(map[string]bool)
+	for _, q := range selected {
+		requested[q.Name] = true
+	}
+	neededBy := make(map[string]string)
+	for _, q := range plan {
+		for _, dep := range q.Depends {
+			if neededBy[dep] == "" {
+				neededBy[dep] = q.Name
+			}
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "\nplan:\n")
+	for i, q := range plan {
+		line := fmt.Sprintf("  %d. %-24s %-5s", i+1, q.Name, queryfile.Kind(q.SQL))
+		if !requested[q.Name] {
+			line += fmt.Sprintf("  (needed by %s)", neededBy[q.Name])
+		}
+		if unbounded := queryfile.UnboundedStatements(q.SQL); len(unbounded) > 0 {
+			line += "  WARNING: " + unbounded[0].Reason
+		}
+		fmt.Fprintln(os.Stderr, strings.TrimRight(line, " "))
+	}
+	return opts.ask(fmt.Sprintf("run these %d queries?", len(plan)))
+}
+
+// checkUnbounded refuses queries with statements that change whole tables
+// when block_unbounded is on, unless they have @allow-unbounded. it looks at
+// all of them before anything runs so a plan doesn't stop halfway, and
+// returns the index of the one it refused.
+func checkUnbounded(queries []queryfile.Query, variables map[string]string, config *Config) (int, error) {
+	if !config.BlockUnbounded {
+		return -1, nil
+	}
+	for i, q := range queries {
+		if q.AllowUnbounded {
+			continue
+		}
+		sql, err := interpolateVariables(q.SQL, variables)
+		if err != nil {
+			return i, err
+		}
+		if unbounded := queryfile.UnboundedStatements(sql); len(unbounded) > 0 {
+			return i, fmt.Errorf("%s: %s is blocked by block_unbounded, add -- @allow-unbounded to the query if it's meant to change the whole table", q.Name, unbounded[0].Reason)
+		}
+	}
+	return -1, nil
+}
+
+// prepareSQL turns the interpolated sql of a query into what is sent to the
+// database for the chosen mode, and works out whether to ask first
+func prepareSQL(sql string, config *Config, opts runOptions) (string, bool, error) {
+	// protected connections ask about every write whatever the config says
+	needsConfirm := opts.confirm || config.Confirm ||
+		((config.ConfirmSchemaChanges || opts.protected()) && containsSchemaChanges(sql)) ||
+		((config.ConfirmUpdates || opts.protected()) && containsUpdates(sql))
+	// some modes don't need to ask about writes
+	onlySchemaChanges := opts.confirm || config.Confirm ||
+		((config.ConfirmSchemaChanges || opts.protected()) && containsSchemaChanges(sql))
+
+	statements := queryfile.SplitStatements(sql)
+	var err error
+	switch {
+	case opts.explain:
+		sql, err = explainSQL(statements, opts.dialect, opts.analyze)
+		// a plain EXPLAIN doesn't touch anything, EXPLAIN ANALYZE runs the query
+		needsConfirm = opts.confirm || config.Confirm || opts.analyze
+	case opts.dryRun:
+		// writes are rolled back
+		sql, err = dryRunSQL(statements, opts.dialect)
+		needsConfirm = onlySchemaChanges
+	case opts.tx:
+		_, err = txStatements(statements, opts.dialect, false)
+		if opts.conn != nil {
+			// writes get their own question before they're committed
+			needsConfirm = onlySchemaChanges
+		}
+	}
+	return sql, needsConfirm, err
+}
+
+// skippedDependency returns the first of q's dependencies that was skipped
+func skippedDependency(q queryfile.Query, skipped map[string]bool) string {
+	for _, dep := range q.Depends {
+		if skipped[dep] {
+			return dep
+		}
+	}
+	return ""
+}
+
+// runQueries interpolates, confirms and then prints or runs each query. with
+// --tx all of them go in one transaction. it stops at the first error and
+// returns what happened to every query.
+func runQueries(queries []queryfile.Query, variables map[string]string, config *Config, opts runOptions, out io.Writer) ([]queryResult, error) {
+	results := make([]queryResult, len(queries))
+	for i, q := range queries {
+		results[i] = queryResult{name: q.Name, status: "not run"}
+	}
+	// captures add variables for the queries after them, keep those to this run
+	variables = copyVariables(variables)
+
+	// a plain explain doesn't run anything
+	if !opts.explain || opts.analyze {
+		if i, err := checkUnbounded(queries, variables, config); err != nil {
+			interpolated, _ := interpolateVariables(queries[i].SQL, variables)
+			opts.history.record(historyEntry{
+				Query:        queries[i].Name,
+				SQLHash:      sqlHash(interpolated),
+				Variables:    usedVariables(queries[i], variables),
+				Mode:         opts.mode(),
+				Confirmation: "not asked",
+				Status:       "blocked",
+			})
+			results[i].status = "blocked"
+			return results, err
+		}
+	}
+
+	var output []string
+	var txQueries []int
+	var txEntries []historyEntry
+	var pending []string
+	// queries that depend on one that was skipped are skipped too, a failure
+	// stops the whole run
+	skipped := make(map[string]bool)
+	for i, q := range queries {
+		if dep := skippedDependency(q, skipped); dep != "" {
+			fmt.Fprintf(os.Stderr, "skipping %s, %s didn't run\n", q.Name, dep)
+			opts.history.record(historyEntry{
+				Query:        q.Name,
+				Variables:    usedVariables(q, variables),
+				Mode:         opts.mode(),
+				Confirmation: "not asked",
+				Status:       "skipped",
+			})
+			results[i].status = "skipped"
+			skipped[q.Name] = true
+			continue
+		}
+
+		interpolated, err := interpolateVariables(q.SQL, variables)
+		if err != nil {
+			results[i].status = "failed"
+			return results, fmt.Errorf("error interpolating variables: %w", err)
+		}
+		sql, needsConfirm, err := prepareSQL(interpolated, config, opts)
+		if err != nil {
+			results[i].status = "failed"
+			return results, fmt.Errorf("%s: %w", q.Name, err)
+		}
+
+		entry := historyEntry{
+			Query:        q.Name,
+			SQLHash:      sqlHash(interpolated),
+			Variables:    usedVariables(q, variables),
+			Mode:         opts.mode(),
+			Confirmation: "not asked",
+		}
+		switch {
+		case opts.confirmed:
+			entry.Confirmation = "plan"
+		case needsConfirm && confirmQuery(q.Name, sql, opts):
+			entry.Confirmation = "yes"
+			if opts.saysYes() {
+				entry.Confirmation = "yes-i-mean-prod"
+			}
+		case needsConfirm:
+			entry.Confirmation = "no"
+			entry.Status = "skipped"
+			opts.history.record(entry)
+			results[i].status = "skipped"
+			skipped[q.Name] = true
+			continue
+		}
+
+		switch {
+		case opts.tx:
+			txQueries = append(txQueries, i)
+			txEntries = append(txEntries, entry)
+			pending = append(pending, queryfile.SplitStatements(sql)...)
+			continue
+		case opts.conn == nil:
+			output = append(output, sql)
+		default:
+			if !opts.explain && !opts.dryRun {
+				// dry runs and explains don't change anything
+				sql = countRowsSQL(sql, opts.dialect)
+			}
+			if format := captureFormat[opts.dialect]; format != "" && len(q.Captures) > 0 {
+				sql = format + "\n" + sql
+			}
+
+			start := time.Now()
+			var captured bytes.Buffer
+			counter := &rowCounter{w: io.MultiWriter(out, &captured)}
+			err := runSQL(*opts.conn, opts.dialect, sql, counter)
+			if flushErr := counter.flush(); err == nil {
+				err = flushErr
+			}
+			if err == nil {
+				err = captureVariables(q, captured.String(), opts.dialect, variables)
+			}
+			entry.DurationMS = time.Since(start).Milliseconds()
+			entry.RowsAffected = counter.rowsAffected()
+			if err != nil {
+				entry.Status = "failed"
+				opts.history.record(entry)
+				results[i].status = "failed"
+				return results, fmt.Errorf("%s: %w", q.Name, err)
+			}
+		}
+		entry.Status = "ok"
+		opts.history.record(entry)
+		results[i].status = "ok"
+	}
+
+	if len(txQueries) > 0 {
+		var names []string
+		for _, i := range txQueries {
+			names = append(names, queries[i].Name)
+		}
+
+		status := "ok"
+		start := time.Now()
+		var err error
+		if opts.conn == nil {
+			var sql string
+			if sql, err = txSQL(pending, opts.dialect); err == nil {
+				output = []string{sql}
+			}
+		} else {
+			err = runTransaction(opts, strings.Join(names, ", "), pending)
+		}
+		if err != nil {
+			status = "rolled back"
+		}
+		for j, i := range txQueries {
+			results[i].status = status
+			txEntries[j].Status = status
+			txEntries[j].DurationMS = time.Since(start).Milliseconds()
+			opts.history.record(txEntries[j])
+		}
+		if err != nil && !errors.Is(err, errNotCommitted) {
+			return results, err
+		}
+	}
+
+	if len(output) > 1 {
+		// make sure one query ends before the next starts
+		for i, sql := range output {
+			if !strings.HasSuffix(sql, ";") {
+				output[i] = sql + ";"
+			}
+		}
+	}
+	fmt.Fprint(out, strings.Join(output, "\n"))
+	return results, nil
+}
+
+// copyVariables copies variables so captures don't leak out of a run
+func copyVariables(variables map[string]string) map[string]string {
+	copied := make(map[string]string, len(variables))
+	for name, value := range variables {
+		copied[name] = value
+	}
+	return copied
+}
+
+// printSummary tells what happened to each query when there were several
+func printSummary(w io.Writer, results []queryResult) {
+	counts := make(map[string]int)
+	var order []string
+	for _, r := range results {
+		if counts[r.status] == 0 {
+			order = append(order, r.status)
+		}
+		counts[r.status]++
+	}
+	var totals []string
+	for _, status := range order {
+		totals = append(totals, fmt.Sprintf("%d %s", counts[status], status))
+	}
+
+	fmt.Fprintf(w, "\nsummary: %s\n", strings.Join(totals, ", "))
+	for _, r := range results {
+		fmt.Fprintf(w, "  %-12s %s\n", r.status, r.name)
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kalli/sqlyac/queryfile"
+)
+
+var runQueriesFixture = []queryfile.Query{
+	{Name: "CreateUsersTable", SQL: "CREATE TABLE users (id INT);", Tags: []string{"setup"}},
+	{Name: "InsertSampleUsers", SQL: "INSERT INTO users VALUES (@id);", Tags: []string{"setup", "fixtures"}},
+	{Name: "GetAllUsers", SQL: "SELECT * FROM users"},
+	{Name: "CleanupTestData", SQL: "DROP TABLE users;", Tags: []string{"teardown"}},
+}
+
+func queryNames(queries []queryfile.Query) []string {
+	var names []string
+	for _, q := range queries {
+		names = append(names, q.Name)
+	}
+	return names
+}
+
+func TestSelectQueries(t *testing.T) {
+	testCases := []struct {
+		names    []string
+		all      bool
+		tag      string
+		expected []string
+		desc     string
+	}{
+		{[]string{"GetAllUsers", "CreateUsersTable"}, false, "", []string{"CreateUsersTable", "GetAllUsers"}, "file order"},
+		{[]string{"getallusers", "GetAllUsers"}, false, "", []string{"GetAllUsers"}, "case-insensitive names and duplicates"},
+		{nil, false, "setup", []string{"CreateUsersTable", "InsertSampleUsers"}, "by tag"},
+		{[]string{"CleanupTestData"}, false, "fixtures", []string{"InsertSampleUsers", "CleanupTestData"}, "names and tags"},
+		{nil, true, "", []string{"CreateUsersTable", "InsertSampleUsers", "GetAllUsers", "CleanupTestData"}, "all"},
+	}
+
+	for _, tc := range testCases {
+		selected, err := selectQueries(runQueriesFixture, tc.names, tc.all, tc.tag)
+		if err != nil {
+			t.Fatalf("%s: selectQueries failed: %v", tc.desc, err)
+		}
+		if got := queryNames(selected); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("%s: expected %v, got %v", tc.desc, tc.expected, got)
+		}
+	}
+
+	if _, err := selectQueries(runQueriesFixture, []string{"Nope"}, false, ""); err == nil {
+		t.Error("expected an error for an unknown name, got none")
+	}
+	// nobody can confirm a guess here
+	if _, err := selectQueries(runQueriesFixture, []string{"getall"}, false, ""); err == nil {
+		t.Error("expected an error for a prefix without a terminal, got none")
+	}
+	if _, err := selectQueries(runQueriesFixture, nil, false, "nope"); err == nil {
+		t.Error("expected an error for a tag nothing has, got none")
+	}
+}
+
+func TestRunQueries(t *testing.T) {
+	originalInput := promptInput
+	defer func() { promptInput = originalInput }()
+
+	config := &Config{ConfirmSchemaChanges: true}
+	variables := map[string]string{"id": "1"}
+
+	// the schema changes ask, say yes to the first one and no to the second
+	promptInput = strings.NewReader("y\nn\n")
+	var out bytes.Buffer
+	results, err := runQueries(runQueriesFixture, variables, config, runOptions{}, &out)
+	if err != nil {
+		t.Fatalf("runQueries failed: %v", err)
+	}
+
+	expected := "CREATE TABLE users (id INT);\nINSERT INTO users VALUES (1);\nSELECT * FROM users;"
+	if out.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+	expectedResults := []queryResult{
+		{"CreateUsersTable", "ok"}, {"InsertSampleUsers", "ok"}, {"GetAllUsers", "ok"}, {"CleanupTestData", "skipped"},
+	}
+	if !reflect.DeepEqual(results, expectedResults) {
+		t.Errorf("expected %v, got %v", expectedResults, results)
+	}
+
+	// a single query is printed as it is
+	out.Reset()
+	if _, err := runQueries(runQueriesFixture[2:3], variables, config, runOptions{}, &out); err != nil {
+		t.Fatalf("runQueries failed: %v", err)
+	}
+	if out.String() != "SELECT * FROM users" {
+		t.Errorf("unexpected output: %q", out.String())
+	}
+}
+
+func TestRunQueriesSkipsDependents(t *testing.T) {
+	originalInput := promptInput
+	defer func() { promptInput = originalInput }()
+
+	queries := []queryfile.Query{
+		{Name: "CreateUsersTable", SQL: "CREATE TABLE users (id INT);"},
+		{Name: "InsertSampleUsers", SQL: "INSERT INTO users VALUES (1);", Depends: []string{"CreateUsersTable"}},
+		{Name: "GetAllUsers", SQL: "SELECT * FROM users;", Depends: []string{"InsertSampleUsers"}},
+	}
+
+	// saying no to the table skips everything that needs it without asking
+	promptInput = strings.NewReader("n\ny\n")
+	var out bytes.Buffer
+	results, err := runQueries(queries, nil, &Config{ConfirmSchemaChanges: true, ConfirmUpdates: true}, runOptions{}, &out)
+	if err != nil {
+		t.Fatalf("runQueries failed: %v", err)
+	}
+	expected := []queryResult{{"CreateUsersTable", "skipped"}, {"InsertSampleUsers", "skipped"}, {"GetAllUsers", "skipped"}}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("expected %v, got %v", expected, results)
+	}
+	if out.Len() > 0 {
+		t.Errorf("expected nothing to be printed, got %q", out.String())
+	}
+}
+
+func TestRunQueriesBlocksUnbounded(t *testing.T) {
+	config := &Config{BlockUnbounded: true}
+	queries := []queryfile.Query{
+		{Name: "DeleteOrder", SQL: "DELETE FROM orders WHERE id = 1;"},
+		{Name: "DeleteOrders", SQL: "DELETE FROM orders;"},
+		{Name: "GetOrders", SQL: "SELECT * FROM orders;"},
+	}
+
+	var out bytes.Buffer
+	results, err := runQueries(queries, nil, config, runOptions{}, &out)
+	if err == nil || !strings.Contains(err.Error(), "DELETE without WHERE") {
+		t.Errorf("expected the unbounded delete to be blocked, got %v", err)
+	}
+	// nothing runs, not even the queries before it
+	expectedResults := []queryResult{{"DeleteOrder", "not run"}, {"DeleteOrders", "blocked"}, {"GetOrders", "not run"}}
+	if !reflect.DeepEqual(results, expectedResults) {
+		t.Errorf("expected %v, got %v", expectedResults, results)
+	}
+
+	// unless the query says it's meant to, or it's only explained
+	queries[1].AllowUnbounded = true
+	if _, err := runQueries(queries, nil, config, runOptions{}, &out); err != nil {
+		t.Errorf("expected @allow-unbounded to run, got %v", err)
+	}
+	queries[1].AllowUnbounded = false
+	if _, err := runQueries(queries[1:2], nil, config, runOptions{explain: true, dialect: "mysql"}, &out); err != nil {
+		t.Errorf("expected an explain to run, got %v", err)
+	}
+	out.Reset()
+	if _, err := runQueries([]queryfile.Query{{Name: "DropEmail", SQL: "ALTER TABLE users DROP COLUMN email;"}}, nil, config, runOptions{}, &out); err == nil || out.Len() > 0 {
+		t.Errorf("expected dropping a column to be blocked, got %q %v", out.String(), err)
+	}
+}
+
+func TestRunQueriesInOneTransaction(t *testing.T) {
+	var out bytes.Buffer
+	opts := runOptions{tx: true, dialect: "sqlite"}
+	results, err := runQueries(runQueriesFixture[:3], map[string]string{"id": "1"}, &Config{}, opts, &out)
+	if err != nil {
+		t.Fatalf("runQueries failed: %v", err)
+	}
+
+	expected := "BEGIN;\nCREATE TABLE users (id INT);\nINSERT INTO users VALUES (1);\nSELECT * FROM users;\nCOMMIT;"
+	if out.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+	for _, r := range results {
+		if r.status != "ok" {
+			t.Errorf("expected %s to be ok, got %s", r.name, r.status)
+		}
+	}
+
+	// the error says which query can't go in a transaction
+	_, err = runQueries([]queryfile.Query{{Name: "Commits", SQL: "UPDATE users SET id = 2; COMMIT;"}}, nil, &Config{}, opts, &out)
+	if err == nil || !strings.Contains(err.Error(), "Commits") {
+		t.Errorf("expected an error about Commits, got %v", err)
+	}
+}
+
+func TestRunQueriesWithConnection(t *testing.T) {
+	conn, query := sqliteConnection(t, "-bail")
+	opts := runOptions{conn: &conn}
+
+	queries := []queryfile.Query{
+		{Name: "AddUser", SQL: "INSERT INTO users VALUES (4, 1);"},
+		{Name: "Broken", SQL: "SELECT nosuch FROM users;"},
+		{Name: "AddAnother", SQL: "INSERT INTO users VALUES (5, 1);"},
+	}
+	results, err := runQueries(queries, nil, &Config{}, opts, &bytes.Buffer{})
+	if err == nil {
+		t.Error("expected an error, got none")
+	}
+	expected := []queryResult{{"AddUser", "ok"}, {"Broken", "failed"}, {"AddAnother", "not run"}}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("expected %v, got %v", expected, results)
+	}
+	if got := query("SELECT COUNT(*) FROM users;"); got != "4" {
+		t.Errorf("expected only the first insert to run, got %s users", got)
+	}
+}
+
+func TestRunQueriesStopsAtFirstError(t *testing.T) {
+	// without -bail, sqlyac turns it on itself
+	conn, query := sqliteConnection(t)
+	opts := runOptions{conn: &conn, dialect: "sqlite"}
+
+	queries := []queryfile.Query{{Name: "AddUsers", SQL: "INSERT INTO nosuch VALUES (1);\nINSERT INTO users VALUES (4, 1);"}}
+	results, err := runQueries(queries, nil, &Config{}, opts, &bytes.Buffer{})
+	if err == nil || results[0].status != "failed" {
+		t.Errorf("expected the query to fail, got %v %v", results, err)
+	}
+	if got := query("SELECT COUNT(*) FROM users;"); got != "3" {
+		t.Errorf("expected nothing after the error to run, got %s users", got)
+	}
+}
+
+func TestPrepareSQLOnProtectedConnection(t *testing.T) {
+	opts := runOptions{conn: &Connection{Name: "prod", Protected: true}, dialect: "sqlite"}
+	dryRun := opts
+	dryRun.dryRun = true
+
+	testCases := []struct {
+		sql      string
+		opts     runOptions
+		expected bool
+	}{
+		{"SELECT * FROM users;", opts, false},
+		{"UPDATE users SET active = 0;", opts, true},
+		{"DROP TABLE users;", opts, true},
+		// dry runs roll writes back
+		{"UPDATE users SET active = 0;", dryRun, false},
+		{"DROP TABLE users;", dryRun, true},
+		{"UPDATE users SET active = 0;", runOptions{}, false},
+	}
+
+	for _, tc := range testCases {
+		_, needsConfirm, err := prepareSQL(tc.sql, &Config{}, tc.opts)
+		if err != nil {
+			t.Fatalf("prepareSQL(%q) failed: %v", tc.sql, err)
+		}
+		if needsConfirm != tc.expected {
+			t.Errorf("prepareSQL(%q, protected %v, dry run %v): expected confirm %v, got %v", tc.sql, tc.opts.protected(), tc.opts.dryRun, tc.expected, needsConfirm)
+		}
+	}
+}
+
+func TestPrintSummary(t *testing.T) {
+	var out bytes.Buffer
+	printSummary(&out, []queryResult{{"A", "ok"}, {"B", "skipped"}, {"C", "ok"}})
+
+	expected := "\nsummary: 2 ok, 1 skipped\n  ok           A\n  skipped      B\n  ok           C\n"
+	if out.String() != expected {
+		t.Errorf("expected:\n%q\ngot:\n%q", expected, out.String())
+	}
+}
+
+func TestPlanQueries(t *testing.T) {
+	queries := []queryfile.Query{
+		{Name: "CreateUsersTable"},
+		{Name: "InsertSampleUsers", Depends: []string{"CreateUsersTable"}},
+		{Name: "GetAllUsers"},
+	}
+
+	plan, hasDeps, err := planQueries(queries, queries[1:], true)
+	if err != nil || !hasDeps {
+		t.Fatalf("planQueries failed: %v %v", hasDeps, err)
+	}
+	if got := queryNames(plan); !reflect.DeepEqual(got, []string{"CreateUsersTable", "InsertSampleUsers", "GetAllUsers"}) {
+		t.Errorf("unexpected plan %v", got)
+	}
+
+	// the dependencies already ran
+	plan, _, err = planQueries(queries, queries[1:], false)
+	if err != nil {
+		t.Fatalf("planQueries failed: %v", err)
+	}
+	if got := queryNames(plan); !reflect.DeepEqual(got, []string{"InsertSampleUsers", "GetAllUsers"}) {
+		t.Errorf("unexpected plan %v", got)
+	}
+
+	if _, hasDeps, _ := planQueries(queries, queries[2:], true); hasDeps {
+		t.Error("expected no dependencies for GetAllUsers")
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kalli/sqlyac/queryfile"
+)
+
+func runSnapshot(args []string) {
+	var connName, tag string
+	var verify, allowWrites, yesIMeanProd bool
+	vars := varFlags{}
+
+	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	fs.BoolVar(&verify, "verify", false, "compare results with the snapshots instead of writing them")
+	fs.StringVar(&connName, "conn", "", "run the queries with a connection from the config instead of a throwaway sqlite database")
+	fs.StringVar(&tag, "tag", "", "only snapshot queries with this tag")
+	fs.Var(vars, "var", "set a variable, overriding the file (name=value, repeatable)")
+	fs.BoolVar(&allowWrites, "allow-writes", false, "let the queries and their dependencies write and change the schema on --conn, nothing asks for confirmation")
+	fs.BoolVar(&yesIMeanProd, "yes-i-mean-prod", false, "run the queries on a protected connection, nothing asks for confirmation")
+	positional := parseArgs(fs, args)
+
+	if len(positional) == 0 {
+		fmt.Fprintf(os.Stderr, "usage: sqlyac snapshot <filepath> [<queryname>...] [--tag <tag>] [--var name=value] [--conn <name> [--allow-writes] [--yes-i-mean-prod]] [--verify]\n")
+		os.Exit(1)
+	}
+	path := positional[0]
+	if path == "-" {
+		fmt.Fprintf(os.Stderr, "error: snapshots are stored next to the query file, read it from a file\n")
+		os.Exit(1)
+	}
+
+	config := loadConfigOrDefaults()
+	queries, variables, err := parseSQLWithOptions(path, config.parseOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error parsing sql: %v\n", err)
+		os.Exit(1)
+	}
+	for name, value := range vars {
+		variables[name] = value
+	}
+
+	selected, err := selectSnapshots(queries, positional[1:], tag)
+	if err == nil {
+		err = checkWrites(queries, selected, connName, allowWrites)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+
+	conn, dialect, cleanup, err := testConnection(config, connName, path, yesIMeanProd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	executions, err := executePlan(queries, selected, variables, config, conn, dialect)
+	// deferred calls don't run on exit
+	cleanup()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+
+	dir := snapshotDir(path)
+	var ok bool
+	if verify {
+		ok = verifySnapshots(os.Stdout, dir, executions)
+	} else {
+		ok = writeSnapshots(dir, executions)
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// selectSnapshots returns the named queries, the ones with a tag, or every
+// query that only reads when there's neither
+func selectSnapshots(queries []queryfile.Query, names []string, tag string) ([]queryfile.Query, error) {
+	if len(names) > 0 || tag != "" {
+		return selectQueries(queries, names, false, tag)
+	}
+
+	var selected []queryfile.Query
+	for _, q := range queries {
+		if queryfile.Kind(q.SQL) == queryfile.KindRead {
+			selected = append(selected, q)
+		}
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no queries that only read to snapshot, name the ones you want")
+	}
+	return selected, nil
+}
+
+// snapshotDir is where the snapshots of a query file go, example.sql has
+// them in example.snapshots
+func snapshotDir(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".snapshots"
+}
+
+// snapshotPath is the file with the snapshot of a query
+func snapshotPath(dir, name string) string {
+	return filepath.Join(dir, name+".tsv")
+}
+
+// resultLines turns a result into the lines of a snapshot, the header first
+func resultLines(r result) []string {
+	if r.columns == nil {
+		return nil
+	}
+	lines := []string{strings.Join(r.columns, "\t")}
+	for _, row := range r.rows {
+		lines = append(lines, strings.Join(row, "\t"))
+	}
+	return lines
+}
+
+// writeSnapshots stores the results of the queries that ran, it reports
+// false when one of them failed
+func writeSnapshots(dir string, executions []execution) bool {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return false
+	}
+
+	ok := true
+	for _, e := range executions {
+		if e.err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", e.query.Name, e.err)
+			ok = false
+			continue
+		}
+		r := parseResult(e.output)
+		content := ""
+		if lines := resultLines(r); lines != nil {
+			content = strings.Join(lines, "\n") + "\n"
+		}
+		path := snapshotPath(dir, e.query.Name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", e.query.Name, err)
+			ok = false
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "wrote %s (%d rows)\n", path, len(r.rows))
+	}
+	return ok
+}
+
+// verifySnapshots compares the results of the queries with their snapshots
+// and shows the rows that changed, it reports false when anything did
+func verifySnapshots(w io.Writer, dir string, executions []execution) bool {
+	ok := true
+	for _, e := range executions {
+		name := e.query.Name
+		if e.err != nil {
+			fmt.Fprintf(w, "failed   %s: %v\n", name, e.err)
+			ok = false
+			continue
+		}
+
+		content, err := os.ReadFile(snapshotPath(dir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintf(w, "missing  %s, run sqlyac snapshot to create it\n", name)
+			ok = false
+			continue
+		} else if err != nil {
+			fmt.Fprintf(w, "failed   %s: %v\n", name, err)
+			ok = false
+			continue
+		}
+
+		expected := resultLines(parseResult(string(content)))
+		got := resultLines(parseResult(e.output))
+		diff := diffLines(expected, got)
+		if len(diff) == 0 {
+			fmt.Fprintf(w, "ok       %s\n", name)
+			continue
+		}
+
+		ok = false
+		removed, added := 0, 0
+		for _, line := range diff {
+			if strings.HasPrefix(line, "-") {
+				removed++
+			} else {
+				added++
+			}
+		}
+		fmt.Fprintf(w, "changed  %s (%d removed, %d added)\n", name, removed, added)
+		if len(got) > 0 {
+			fmt.Fprintf(w, "    %s\n", strings.ReplaceAll(got[0], "\t", " | "))
+		}
+		for _, line := range diff {
+			fmt.Fprintf(w, "  %s\n", strings.ReplaceAll(line, "\t", " | "))
+		}
+	}
+	return ok
+}
+
+// diffLines returns the lines only in a prefixed with "- " and the lines
+// only in b prefixed with "+ ", in order, using the longest common
+// subsequence of the two
+func diffLines(a, b []string) []string {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var diff []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			diff = append(diff, "- "+a[i])
+			i++
+		default:
+			diff = append(diff, "+ "+b[j])
+			j++
+		}
+	}
+	return diff
+}
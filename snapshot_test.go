@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/kalli/sqlyac/queryfile"
+)
+
+func TestDiffLines(t *testing.T) {
+	testCases := []struct {
+		a, b     []string
+		expected []string
+	}{
+		{[]string{"id", "1", "2"}, []string{"id", "1", "2"}, nil},
+		{[]string{"id", "1", "2"}, []string{"id", "1", "3"}, []string{"- 2", "+ 3"}},
+		{[]string{"id", "1"}, []string{"id", "0", "1", "2"}, []string{"+ 0", "+ 2"}},
+		{[]string{"id", "1", "2"}, nil, []string{"- id", "- 1", "- 2"}},
+		{[]string{"a", "b", "c"}, []string{"a", "c", "b"}, []string{"- b", "+ b"}},
+	}
+
+	for _, tc := range testCases {
+		if got := diffLines(tc.a, tc.b); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("diffLines(%v, %v) = %v, expected %v", tc.a, tc.b, got, tc.expected)
+		}
+	}
+}
+
+func TestSnapshotDir(t *testing.T) {
+	if dir := snapshotDir(filepath.Join("queries", "reports.sql")); dir != filepath.Join("queries", "reports.snapshots") {
+		t.Errorf("unexpected snapshot dir %s", dir)
+	}
+}
+
+func TestSelectSnapshots(t *testing.T) {
+	queries := []queryfile.Query{
+		{Name: "CreateUsers", SQL: "CREATE TABLE users (id INT);"},
+		{Name: "GetUsers", SQL: "SELECT * FROM users;", Tags: []string{"reports"}},
+		{Name: "DeleteUsers", SQL: "DELETE FROM users;", Tags: []string{"reports"}},
+	}
+
+	selected, err := selectSnapshots(queries, nil, "")
+	if err != nil || !reflect.DeepEqual(queryNames(selected), []string{"GetUsers"}) {
+		t.Errorf("expected only the reads, got %v %v", queryNames(selected), err)
+	}
+	selected, err = selectSnapshots(queries, nil, "reports")
+	if err != nil || len(selected) != 2 {
+		t.Errorf("expected the tagged queries, got %v %v", queryNames(selected), err)
+	}
+	if _, err := selectSnapshots(queries[:1], nil, ""); err == nil {
+		t.Error("expected an error without reads, got none")
+	}
+
+	// the tagged delete can't run on a connection without --allow-writes
+	if err := checkWrites(queries, selected, "fixtures", false); err == nil {
+		t.Error("expected the delete to be refused on a connection, got no error")
+	}
+	if err := checkWrites(queries, selected, "", false); err != nil {
+		t.Errorf("expected writes to be fine on the throwaway database, got %v", err)
+	}
+}
+
+func TestSnapshotBlocksUnbounded(t *testing.T) {
+	queries := []queryfile.Query{
+		{Name: "DropColumn", SQL: "ALTER TABLE users DROP COLUMN email;"},
+		{Name: "GetUsers", SQL: "SELECT * FROM users;", Depends: []string{"DropColumn"}},
+	}
+	conn := Connection{Dialect: "sqlite", Command: []string{"false"}}
+
+	executions, err := executePlan(queries, queries[1:], nil, &Config{BlockUnbounded: true}, conn, "sqlite")
+	if err == nil || executions != nil {
+		t.Errorf("expected the plan to be refused before running, got %v %v", executions, err)
+	}
+}
+
+func TestWriteAndVerifySnapshots(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "example.snapshots")
+	executions := []execution{
+		{query: queryfile.Query{Name: "GetUsers"}, output: "id\tname\n1\talice\n2\tbob\n"},
+		{query: queryfile.Query{Name: "NoUsers"}, output: ""},
+	}
+
+	if !writeSnapshots(dir, executions) {
+		t.Fatal("writeSnapshots failed")
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "GetUsers.tsv"))
+	if err != nil || string(content) != "id\tname\n1\talice\n2\tbob\n" {
+		t.Errorf("unexpected snapshot %q %v", content, err)
+	}
+
+	var out bytes.Buffer
+	if !verifySnapshots(&out, dir, executions) {
+		t.Errorf("expected the snapshots to match, got:\n%s", out.String())
+	}
+
+	out.Reset()
+	changed := []execution{
+		{query: queryfile.Query{Name: "GetUsers"}, output: "id\tname\n1\talice\n2\tbobby\n"},
+		{query: queryfile.Query{Name: "Missing"}, output: "id\n1\n"},
+		{query: queryfile.Query{Name: "Broken"}, err: errors.New("no such table")},
+	}
+	if verifySnapshots(&out, dir, changed) {
+		t.Error("expected the verification to fail")
+	}
+	expected := `changed  GetUsers (1 removed, 1 added)
+    id | name
+  - 2 | bob
+  + 2 | bobby
+missing  Missing, run sqlyac snapshot to create it
+failed   Broken: no such table
+`
+	if out.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+
+	// a query that failed doesn't overwrite its snapshot
+	if writeSnapshots(dir, changed[2:]) {
+		t.Error("expected writeSnapshots to report the failure")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "Broken.tsv")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected no snapshot for Broken, got %v", err)
+	}
+}
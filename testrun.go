@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kalli/sqlyac/queryfile"
+)
+
+// testResult is the outcome of running a query with `sqlyac test`
+type testResult struct {
+	name     string
+	location string
+	failures []string
+	duration time.Duration
+}
+
+func runTest(args []string) {
+	var connName, format, tag string
+	var allowWrites, yesIMeanProd bool
+	vars := varFlags{}
+
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	fs.StringVar(&connName, "conn", "", "run the tests with a connection from the config instead of a throwaway sqlite database")
+	fs.StringVar(&format, "format", "tap", "report format, tap or junit")
+	fs.StringVar(&tag, "tag", "", "only run tests with this tag")
+	fs.Var(vars, "var", "set a variable, overriding the file (name=value, repeatable)")
+	fs.BoolVar(&allowWrites, "allow-writes", false, "let the tests and their dependencies write and change the schema on --conn, nothing asks for confirmation")
+	fs.BoolVar(&yesIMeanProd, "yes-i-mean-prod", false, "run the tests on a protected connection, nothing asks for confirmation")
+	positional := parseArgs(fs, args)
+
+	if len(positional) == 0 {
+		fmt.Fprintf(os.Stderr, "usage: sqlyac test <filepath> [<queryname>...] [--tag <tag>] [--var name=value] [--conn <name> [--allow-writes] [--yes-i-mean-prod]] [--format tap|junit]\n")
+		os.Exit(1)
+	}
+	if format != "tap" && format != "junit" {
+		fmt.Fprintf(os.Stderr, "error: --format must be tap or junit\n")
+		os.Exit(1)
+	}
+
+	config := loadConfigOrDefaults()
+	path := positional[0]
+	queries, variables, err := parseSQLWithOptions(path, config.parseOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error parsing sql: %v\n", err)
+		os.Exit(1)
+	}
+	for name, value := range vars {
+		variables[name] = value
+	}
+
+	tests, err := selectTests(queries, positional[1:], tag)
+	if err == nil {
+		err = checkWrites(queries, tests, connName, allowWrites)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+
+	conn, dialect, cleanup, err := testConnection(config, connName, path, yesIMeanProd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+
+	results, err := runTests(queries, tests, variables, config, conn, dialect)
+	// deferred calls don't run on exit
+	cleanup()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if format == "junit" {
+		err = writeJUnit(os.Stdout, path, results)
+	} else {
+		writeTAP(os.Stdout, results)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error writing report: %v\n", err)
+		os.Exit(1)
+	}
+	for _, r := range results {
+		if len(r.failures) > 0 {
+			os.Exit(1)
+		}
+	}
+}
+
+// selectTests returns the named queries, or the ones with @assert or
+// @expect-error when no names are given, optionally only those with a tag
+func selectTests(queries []queryfile.Query, names []string, tag string) ([]queryfile.Query, error) {
+	if len(names) > 0 {
+		return selectQueries(queries, names, false, "")
+	}
+
+	var tests []queryfile.Query
+	for _, q := range queries {
+		if (len(q.Asserts) > 0 || q.ExpectError) && (tag == "" || hasTag(q, tag)) {
+			tests = append(tests, q)
+		}
+	}
+	if len(tests) == 0 {
+		return nil, fmt.Errorf("no queries with @assert or @expect-error to test")
+	}
+	return tests, nil
+}
+
+// testConnection returns the connection to run tests and snapshots with,
+// the one named in the config or a throwaway sqlite database that is
+// removed by the returned function. they never ask for confirmation, so a
+// protected connection needs yesIMeanProd.
+func testConnection(config *Config, connName, path string, yesIMeanProd bool) (Connection, string, func(), error) {
+	if connName == "" {
+		conn, cleanup, err := throwawayDatabase()
+		return conn, "sqlite", cleanup, err
+	}
+	conn, err := config.connection(connName)
+	if err == nil {
+		err = checkProtected(conn, false, yesIMeanProd)
+	}
+	if err != nil {
+		return Connection{}, "", func() {}, err
+	}
+	configDialect := config.Dialect
+	if conn.Dialect != "" {
+		configDialect = conn.Dialect
+	}
+	dialect, err := resolveDialect("", configDialect, path)
+	return conn, dialect, func() {}, err
+}
+
+// checkWrites refuses to run targets or dependencies that write or change
+// the schema on a connection from the config unless allowWrites. tests and
+// snapshots never ask for confirmation, only the throwaway database is
+// theirs to change.
+func checkWrites(queries, targets []queryfile.Query, connName string, allowWrites bool) error {
+	if connName == "" || allowWrites {
+		return nil
+	}
+	var names []string
+	for _, q := range targets {
+		names = append(names, q.Name)
+	}
+	plan, err := queryfile.Plan(queries, names...)
+	if err != nil {
+		return err
+	}
+	for _, q := range plan {
+		switch queryfile.Kind(q.SQL) {
+		case queryfile.KindDDL:
+			return fmt.Errorf("%s changes the schema and would run on %s without confirmation, pass --allow-writes to let it", q.Name, connName)
+		case queryfile.KindWrite:
+			return fmt.Errorf("%s writes and would run on %s without confirmation, pass --allow-writes to let it", q.Name, connName)
+		}
+	}
+	return nil
+}
+
+// throwawayDatabase is a connection to an empty sqlite database that is
+// removed by the returned function
+func throwawayDatabase() (Connection, func(), error) {
+	sqlite, err := exec.LookPath("sqlite3")
+	if err != nil {
+		return Connection{}, func() {}, fmt.Errorf("tests run against a throwaway sqlite database, install sqlite3 or use --conn")
+	}
+	dir, err := os.MkdirTemp("", "sqlyac-test")
+	if err != nil {
+		return Connection{}, func() {}, err
+	}
+	conn := Connection{Dialect: "sqlite", Command: []string{sqlite, "-bail", filepath.Join(dir, "test.db")}}
+	return conn, func() { os.RemoveAll(dir) }, nil
+}
+
+// runTests runs the tests and checks their results
+func runTests(queries, tests []queryfile.Query, variables map[string]string, config *Config, conn Connection, dialect string) ([]testResult, error) {
+	executions, err := executePlan(queries, tests, variables, config, conn, dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []testResult
+	for _, e := range executions {
+		q := e.query
+		r := testResult{name: q.Name, location: fmt.Sprintf("%s:%d", q.File, q.Line), duration: e.duration}
+		switch {
+		case e.skipped:
+			r.failures = append(r.failures, e.err.Error())
+		case q.ExpectError && e.err == nil:
+			r.failures = append(r.failures, "expected an error, the query succeeded")
+		case q.ExpectError:
+			// that's what we wanted
+		case e.err != nil:
+			r.failures = append(r.failures, e.err.Error())
+		default:
+			result := parseResult(e.output)
+			for _, expr := range q.Asserts {
+				if err := checkAssert(expr, result, dialect); err != nil {
+					r.failures = append(r.failures, err.Error())
+				}
+			}
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// execution is what happened when one of the targets of a plan ran
+type execution struct {
+	query  queryfile.Query
+	output string
+	// err is set when the query failed, skipped is set too when it didn't
+	// run because a query it depends on failed
+	err      error
+	skipped  bool
+	duration time.Duration
+}
+
+// executePlan runs the targets in an order where the queries they @depends
+// on run first, printing results in a format parseResult can read. every
+// query runs once, and when one fails the queries that depend on it aren't
+// run. queries with @expect-error can fail without stopping anything.
+// block_unbounded is checked for the whole plan before anything runs.
+func executePlan(queries, targets []queryfile.Query, variables map[string]string, config *Config, conn Connection, dialect string) ([]execution, error) {
+	var names []string
+	isTarget := make(map[string]bool)
+	for _, q := range targets {
+		names = append(names, q.Name)
+		isTarget[q.Name] = true
+	}
+	plan, err := queryfile.Plan(queries, names...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := checkUnbounded(plan, variables, config); err != nil {
+		return nil, err
+	}
+
+	variables = copyVariables(variables)
+	failed := make(map[string]bool)
+	var executions []execution
+	for _, q := range plan {
+		e := execution{query: q}
+		for _, dep := range q.Depends {
+			if failed[dep] && e.err == nil {
+				e.err = fmt.Errorf("dependency %s failed", dep)
+				e.skipped = true
+			}
+		}
+
+		if !e.skipped {
+			sql, err := interpolateVariables(q.SQL, variables)
+			if err != nil {
+				return nil, err
+			}
+			start := time.Now()
+			output, errOutput, err := querySQL(conn, dialect, captureFormat[dialect]+"\n"+sql)
+			e.output, e.duration = output, time.Since(start)
+			if err != nil {
+				if message := strings.TrimSpace(errOutput); message != "" {
+					err = errors.New(message)
+				}
+				e.err = err
+			} else {
+				e.err = captureVariables(q, output, dialect, variables)
+			}
+		}
+		if e.err != nil && (e.skipped || !q.ExpectError) {
+			failed[q.Name] = true
+		}
+
+		if isTarget[q.Name] {
+			executions = append(executions, e)
+		} else if failed[q.Name] {
+			// dependencies aren't reported, the queries that need them are
+			fmt.Fprintf(os.Stderr, "%s: %v\n", q.Name, e.err)
+		}
+	}
+	return executions, nil
+}
+
+// querySQL runs sql with the connection's client and returns its output. the
+// client stops at the first error and exits with an error status.
+func querySQL(conn Connection, dialect, sql string) (string, string, error) {
+	var stdout, stderr bytes.Buffer
+	if prelude := stopOnError[dialect]; prelude != "" {
+		sql = prelude + "\n" + sql
+	}
+	cmd := exec.Command(conn.Command[0], conn.Command[1:]...)
+	cmd.Stdin = strings.NewReader(sql + "\n")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	return stdout.String(), stderr.String(), err
+}
+
+// writeTAP writes the results in the test anything protocol
+func writeTAP(w io.Writer, results []testResult) {
+	fmt.Fprintf(w, "TAP version 13\n1..%d\n", len(results))
+	for i, r := range results {
+		if len(r.failures) == 0 {
+			fmt.Fprintf(w, "ok %d - %s\n", i+1, r.name)
+			continue
+		}
+		fmt.Fprintf(w, "not ok %d - %s\n", i+1, r.name)
+		fmt.Fprintf(w, "  ---\n  at: %s\n  failures:\n", r.location)
+		for _, failure := range r.failures {
+			fmt.Fprintf(w, "    - %s\n", strconv.Quote(failure))
+		}
+		fmt.Fprintf(w, "  ...\n")
+	}
+}
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",cdata"`
+}
+
+// writeJUnit writes the results as junit xml, which most ci systems show
+func writeJUnit(w io.Writer, path string, results []testResult) error {
+	suite := junitSuite{Name: path, Tests: len(results)}
+	var total time.Duration
+	for _, r := range results {
+		c := junitCase{Name: r.name, ClassName: path, Time: seconds(r.duration)}
+		if len(r.failures) > 0 {
+			suite.Failures++
+			c.Failure = &junitFailure{
+				Message: r.failures[0],
+				Text:    r.location + "\n" + strings.Join(r.failures, "\n"),
+			}
+		}
+		suite.Cases = append(suite.Cases, c)
+		total += r.duration
+	}
+	suite.Time = seconds(total)
+
+	out, err := xml.MarshalIndent(junitSuites{Suites: []junitSuite{suite}}, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s%s\n", xml.Header, out)
+	return err
+}
+
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/kalli/sqlyac/queryfile"
+)
+
+const testRunSQL = `---
+-- @name CreateUsers
+CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT UNIQUE, status TEXT);
+INSERT INTO users (email, status) VALUES ('a@x', 'active'), ('b@x', 'pending');
+---
+-- @name ActiveUsers
+-- @depends CreateUsers
+-- @assert rowcount = 2
+-- @assert col(status) in ('active', 'pending')
+SELECT * FROM users;
+---
+-- @name DuplicateEmail
+-- @depends CreateUsers
+-- @expect-error
+INSERT INTO users (email) VALUES ('a@x');
+---
+-- @name OnlyActive
+-- @depends CreateUsers
+-- @assert col(status) = 'active'
+SELECT status FROM users;
+---
+-- @name Broken
+-- @assert rowcount > 0
+SELECT * FROM nosuch;
+---
+-- @name NeedsBroken
+-- @depends Broken
+-- @assert rowcount > 0
+SELECT 1;
+---`
+
+func TestRunTests(t *testing.T) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("sqlite3 not installed")
+	}
+	file, err := queryfile.Parse(strings.NewReader(testRunSQL), "tests.sql", queryfile.Options{})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	tests, err := selectTests(file.Queries, nil, "")
+	if err != nil {
+		t.Fatalf("selectTests failed: %v", err)
+	}
+
+	conn, cleanup, err := throwawayDatabase()
+	if err != nil {
+		t.Fatalf("throwawayDatabase failed: %v", err)
+	}
+	defer cleanup()
+
+	results, err := runTests(file.Queries, tests, nil, &Config{}, conn, "sqlite")
+	if err != nil {
+		t.Fatalf("runTests failed: %v", err)
+	}
+
+	expected := map[string]string{
+		"ActiveUsers":    "",
+		"DuplicateEmail": "",
+		"OnlyActive":     "row 2 has status = 'pending'",
+		"Broken":         "no such table",
+		"NeedsBroken":    "dependency Broken failed",
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("expected %d results, got %d", len(expected), len(results))
+	}
+	for _, r := range results {
+		failure := strings.Join(r.failures, "\n")
+		if want := expected[r.name]; (want == "") != (failure == "") || !strings.Contains(failure, want) {
+			t.Errorf("%s: expected failure %q, got %q", r.name, want, failure)
+		}
+	}
+}
+
+func TestRunTestsBlocksUnbounded(t *testing.T) {
+	queries := []queryfile.Query{
+		{Name: "ResetUsers", SQL: "DELETE FROM users;"},
+		{Name: "NoUsers", SQL: "SELECT * FROM users;", Depends: []string{"ResetUsers"}, Asserts: []string{"rowcount = 0"}},
+	}
+	// the client would fail the test if it ran, the check comes first
+	conn := Connection{Dialect: "sqlite", Command: []string{"false"}}
+
+	_, err := runTests(queries, queries[1:], nil, &Config{BlockUnbounded: true}, conn, "sqlite")
+	if err == nil || !strings.Contains(err.Error(), "ResetUsers: DELETE without WHERE is blocked") {
+		t.Errorf("expected the dependency to be blocked, got %v", err)
+	}
+}
+
+func TestSelectTests(t *testing.T) {
+	queries := []queryfile.Query{
+		{Name: "Setup"},
+		{Name: "Check", Asserts: []string{"rowcount > 0"}, Tags: []string{"quality"}},
+		{Name: "Fails", ExpectError: true},
+	}
+
+	tests, err := selectTests(queries, nil, "")
+	if err != nil || len(tests) != 2 {
+		t.Errorf("expected the 2 annotated queries, got %v %v", queryNames(tests), err)
+	}
+	if tests, _ := selectTests(queries, nil, "quality"); len(tests) != 1 || tests[0].Name != "Check" {
+		t.Errorf("expected Check, got %v", queryNames(tests))
+	}
+	if tests, _ := selectTests(queries, []string{"Setup"}, ""); len(tests) != 1 || tests[0].Name != "Setup" {
+		t.Errorf("expected Setup, got %v", queryNames(tests))
+	}
+	if _, err := selectTests(queries[:1], nil, ""); err == nil {
+		t.Error("expected an error without tests, got none")
+	}
+}
+
+func TestCheckWrites(t *testing.T) {
+	file, err := queryfile.Parse(strings.NewReader(testRunSQL), "tests.sql", queryfile.Options{})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	only := func(names ...string) []queryfile.Query {
+		queries, err := selectQueries(file.Queries, names, false, "")
+		if err != nil {
+			t.Fatalf("selectQueries failed: %v", err)
+		}
+		return queries
+	}
+
+	testCases := []struct {
+		targets     []queryfile.Query
+		connName    string
+		allowWrites bool
+		expected    string
+	}{
+		{only("ActiveUsers"), "", false, ""},
+		{only("ActiveUsers"), "staging", false, "CreateUsers changes the schema and would run on staging"},
+		{only("DuplicateEmail"), "staging", true, ""},
+		{only("Broken", "NeedsBroken"), "staging", false, ""},
+	}
+
+	for _, tc := range testCases {
+		err := checkWrites(file.Queries, tc.targets, tc.connName, tc.allowWrites)
+		if (tc.expected == "") != (err == nil) || (err != nil && !strings.Contains(err.Error(), tc.expected)) {
+			t.Errorf("%v on %q: expected %q, got %v", queryNames(tc.targets), tc.connName, tc.expected, err)
+		}
+	}
+}
+
+func TestWriteTAP(t *testing.T) {
+	var out bytes.Buffer
+	writeTAP(&out, []testResult{
+		{name: "A", location: "tests.sql:2"},
+		{name: "B", location: "tests.sql:8", failures: []string{"expected rowcount > 0, got 0"}},
+	})
+
+	expected := `TAP version 13
+1..2
+ok 1 - A
+not ok 2 - B
+  ---
+  at: tests.sql:8
+  failures:
+    - "expected rowcount > 0, got 0"
+  ...
+`
+	if out.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	var out bytes.Buffer
+	err := writeJUnit(&out, "tests.sql", []testResult{
+		{name: "A", location: "tests.sql:2"},
+		{name: "B", location: "tests.sql:8", failures: []string{"expected rowcount > 0, got 0"}},
+	})
+	if err != nil {
+		t.Fatalf("writeJUnit failed: %v", err)
+	}
+
+	for _, want := range []string{
+		`<testsuite name="tests.sql" tests="2" failures="1"`,
+		`<testcase name="A" classname="tests.sql" time="0.000"></testcase>`,
+		`<failure message="expected rowcount &gt; 0, got 0"><![CDATA[tests.sql:8`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected the report to contain %s, got:\n%s", want, out.String())
+		}
+	}
+}
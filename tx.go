@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/kalli/sqlyac/queryfile"
+)
+
+// errNotCommitted is returned when the user decides not to commit
+var errNotCommitted = errors.New("rolled back")
+
+// beginStatement starts a transaction in the dialect
+func beginStatement(dialect string) string {
+	if dialect == "mysql" {
+		return "START TRANSACTION;"
+	}
+	return "BEGIN;"
+}
+
+// txStatements checks the statements can be run in a transaction of ours and
+// returns them with their delimiters. with rowsAffected every write is
+// followed by a select of the rows it affected, psql prints those itself so
+// it doesn't need one.
+func txStatements(statements []string, dialect string, rowsAffected bool) ([]string, error) {
+	rowCount := map[string]string{"mysql": "ROW_COUNT()", "sqlite": "changes()"}[dialect]
+
+	var lines []string
+	for _, statement := range statements {
+		if err := checkTransactionControl(statement); err != nil {
+			return nil, err
+		}
+		kind := queryfile.Kind(statement)
+		if kind == queryfile.KindDDL && dialect == "mysql" {
+			return nil, fmt.Errorf("mysql commits schema changes right away, they can't be rolled back: %s", statementSummary(statement))
+		}
+		lines = append(lines, statement+";")
+		if rowsAffected && kind == queryfile.KindWrite && rowCount != "" {
+			lines = append(lines, fmt.Sprintf("SELECT %s AS rows_affected;", rowCount))
+		}
+	}
+	return lines, nil
+}
+
+// txSQL wraps the statements in a transaction that commits at the end. a
+// client that stops at the first error (mysql does by default, use
+// `sqlite3 -bail` and `psql -v ON_ERROR_STOP=1`) never gets to the commit,
+// so the database rolls everything back when it disconnects.
+func txSQL(statements []string, dialect string) (string, error) {
+	lines, err := txStatements(statements, dialect, false)
+	if err != nil {
+		return "", err
+	}
+	return beginStatement(dialect) + "\n" + strings.Join(lines, "\n") + "\nCOMMIT;", nil
+}
+
+// runTransaction runs the statements in a transaction on the connection,
+// shows their output and the rows they affected, and asks whether to commit.
+// the first error rolls everything back.
+func runTransaction(opts runOptions, queryName string, statements []string) error {
+	lines, err := txStatements(statements, opts.dialect, true)
+	if err != nil {
+		return err
+	}
+
+	s, err := startSession(*opts.conn, opts.dialect, os.Stdout)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	if err := s.exec(beginStatement(opts.dialect) + "\n" + strings.Join(lines, "\n")); err != nil {
+		s.exec("ROLLBACK;")
+		return fmt.Errorf("%w, rolled back", err)
+	}
+
+	if !opts.ask(fmt.Sprintf("commit %s?", queryName)) {
+		if err := s.exec("ROLLBACK;"); err != nil {
+			return err
+		}
+		return errNotCommitted
+	}
+	return s.exec("COMMIT;")
+}
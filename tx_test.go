@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTxSQL(t *testing.T) {
+	statements := []string{"INSERT INTO users VALUES (1)", "UPDATE users SET active = 0"}
+
+	sql, err := txSQL(statements, "mysql")
+	if err != nil {
+		t.Fatalf("txSQL failed: %v", err)
+	}
+	expected := "START TRANSACTION;\nINSERT INTO users VALUES (1);\nUPDATE users SET active = 0;\nCOMMIT;"
+	if sql != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, sql)
+	}
+
+	if _, err := txSQL([]string{"CREATE TABLE t (id INT)"}, "mysql"); err == nil {
+		t.Error("expected an error for a schema change on mysql, got none")
+	}
+	if _, err := txSQL([]string{"UPDATE users SET active = 0", "COMMIT"}, "postgres"); err == nil {
+		t.Error("expected an error for a query that commits itself, got none")
+	}
+}
+
+func TestConfigConnection(t *testing.T) {
+	config := &Config{Connections: map[string]Connection{
+		"local": {Dialect: "sqlite", Command: []string{"sqlite3", "app.db"}},
+		"empty": {Dialect: "sqlite"},
+	}}
+
+	if conn, err := config.connection("local"); err != nil || conn.Command[0] != "sqlite3" || conn.Name != "local" {
+		t.Errorf("expected the local connection, got %v %v", conn, err)
+	}
+	if _, err := config.connection("empty"); err == nil {
+		t.Error("expected an error for a connection without a command, got none")
+	}
+	_, err := config.connection("prod")
+	if err == nil || !strings.Contains(err.Error(), "empty, local") {
+		t.Errorf("expected an error listing the connections, got %v", err)
+	}
+}
+
+func TestCheckProtected(t *testing.T) {
+	prod := Connection{Name: "prod", Protected: true}
+	if err := checkProtected(prod, false, false); err == nil || !strings.Contains(err.Error(), "--yes-i-mean-prod") {
+		t.Errorf("expected a protected connection to be refused without a terminal, got %v", err)
+	}
+	if err := checkProtected(prod, true, false); err != nil {
+		t.Errorf("expected a terminal to be enough, got %v", err)
+	}
+	if err := checkProtected(prod, false, true); err != nil {
+		t.Errorf("expected --yes-i-mean-prod to be enough, got %v", err)
+	}
+	if err := checkProtected(Connection{Name: "local"}, false, false); err != nil {
+		t.Errorf("expected an unprotected connection to be fine, got %v", err)
+	}
+}
+
+// sqliteConnection returns a connection to a fresh sqlite database with a
+// users table, skipping the test when sqlite3 isn't installed
+func sqliteConnection(t *testing.T, flags ...string) (Connection, func(sql string) string) {
+	sqlite, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 not installed")
+	}
+	db := filepath.Join(t.TempDir(), "test.db")
+
+	query := func(sql string) string {
+		out, err := exec.Command(sqlite, db, sql).CombinedOutput()
+		if err != nil {
+			t.Fatalf("sqlite3 failed: %v\n%s", err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	query("CREATE TABLE users (id INTEGER, active INTEGER); INSERT INTO users VALUES (1, 1), (2, 1), (3, 0);")
+
+	command := append(append([]string{sqlite}, flags...), db)
+	return Connection{Dialect: "sqlite", Command: command}, query
+}
+
+func TestSession(t *testing.T) {
+	conn, _ := sqliteConnection(t)
+
+	var out bytes.Buffer
+	s, err := startSession(conn, "sqlite", &out)
+	if err != nil {
+		t.Fatalf("startSession failed: %v", err)
+	}
+	defer s.close()
+
+	if err := s.exec("SELECT COUNT(*) FROM users;\nSELECT 'two';"); err != nil {
+		t.Fatalf("exec failed: %v", err)
+	}
+	if out.String() != "3\ntwo\n" {
+		t.Errorf("unexpected output: %q", out.String())
+	}
+
+	// the client stops at the first error, nothing after it runs
+	out.Reset()
+	if err := s.exec("SELECT nosuch FROM users;\nSELECT 'after';"); err == nil {
+		t.Error("expected an error, got none")
+	}
+	if out.String() != "" {
+		t.Errorf("expected nothing to run after the error, got %q", out.String())
+	}
+	if err := s.exec("SELECT 1;"); err == nil {
+		t.Error("expected an error after the client stopped, got none")
+	}
+}
+
+func TestRunTransaction(t *testing.T) {
+	originalInput := promptInput
+	defer func() { promptInput = originalInput }()
+
+	statements := []string{"UPDATE users SET active = 0 WHERE active = 1", "DELETE FROM users WHERE id = 3"}
+
+	// saying no rolls back
+	conn, query := sqliteConnection(t, "-bail")
+	promptInput = strings.NewReader("n\n")
+	if err := runTransaction(runOptions{conn: &conn, dialect: "sqlite"}, "Deactivate", statements); !errors.Is(err, errNotCommitted) {
+		t.Errorf("expected errNotCommitted, got %v", err)
+	}
+	if got := query("SELECT COUNT(*) FROM users WHERE active = 1;"); got != "2" {
+		t.Errorf("expected the update to be rolled back, got %s active users", got)
+	}
+
+	// saying yes commits
+	promptInput = strings.NewReader("y\n")
+	if err := runTransaction(runOptions{conn: &conn, dialect: "sqlite"}, "Deactivate", statements); err != nil {
+		t.Fatalf("runTransaction failed: %v", err)
+	}
+	if got := query("SELECT COUNT(*) FROM users;"); got != "2" {
+		t.Errorf("expected the delete to be committed, got %s users", got)
+	}
+
+	// an error rolls back without asking, with or without -bail
+	for _, flags := range [][]string{{"-bail"}, nil} {
+		conn, query := sqliteConnection(t, flags...)
+		promptInput = strings.NewReader("y\n")
+		err := runTransaction(runOptions{conn: &conn, dialect: "sqlite"}, "Broken", []string{"DELETE FROM users", "SELECT nosuch FROM users"})
+		if err == nil || errors.Is(err, errNotCommitted) {
+			t.Errorf("expected an error with flags %v, got %v", flags, err)
+		}
+		if got := query("SELECT COUNT(*) FROM users;"); got != "3" {
+			t.Errorf("expected the delete to be rolled back with flags %v, got %s users", flags, got)
+		}
+	}
+
+	// a protected connection wants its name, not y
+	conn, query = sqliteConnection(t, "-bail")
+	conn.Name, conn.Protected = "prod", true
+	opts := runOptions{conn: &conn, dialect: "sqlite"}
+	promptInput = strings.NewReader("y\n")
+	if err := runTransaction(opts, "Deactivate", statements); !errors.Is(err, errNotCommitted) {
+		t.Errorf("expected y not to commit on a protected connection, got %v", err)
+	}
+	promptInput = strings.NewReader("prod\n")
+	if err := runTransaction(opts, "Deactivate", statements); err != nil {
+		t.Fatalf("runTransaction failed: %v", err)
+	}
+	if got := query("SELECT COUNT(*) FROM users;"); got != "2" {
+		t.Errorf("expected the delete to be committed, got %s users", got)
+	}
+}
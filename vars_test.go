@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+)
+
+func TestVarFlags(t *testing.T) {
+	vars := varFlags{}
+	if err := vars.Set("user_id=5"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := vars.Set(`@status="completed"`); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	expected := varFlags{"user_id": "5", "status": `"completed"`}
+	if !reflect.DeepEqual(vars, expected) {
+		t.Errorf("expected %v, got %v", expected, vars)
+	}
+	if err := vars.Set("novalue"); err == nil {
+		t.Error("expected an error for a var without a value, got none")
+	}
+}
+
+func TestVarFlagsAfterPositionalArgs(t *testing.T) {
+	fs := flag.NewFlagSet("sqlyac", flag.ContinueOnError)
+	vars := varFlags{}
+	fs.Var(vars, "var", "")
+
+	args := parseArgs(fs, []string{"example.sql", "--var", "user_id=5", "QueryWithVariables", "--var", "user_id=6"})
+	if !reflect.DeepEqual(args, []string{"example.sql", "QueryWithVariables"}) {
+		t.Errorf("unexpected positional args %q", args)
+	}
+	// the last one wins
+	if !reflect.DeepEqual(vars, varFlags{"user_id": "6"}) {
+		t.Errorf("unexpected vars %v", vars)
+	}
+}